@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/text/encoding/htmlindex"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Inline images bigger than this are dropped instead of being embedded into the content.
+const emailMaxInlineImageSize = 1024 * 1024
+
+type emailPart struct {
+	mimeType  string
+	contentId string
+	body      []byte
+}
+
+type emailParts struct {
+	html   *emailPart
+	text   *emailPart
+	inline []*emailPart
+}
+
+var mimeWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	},
+}
+
+func IsEmail(feedUrl url.URL) bool {
+	return strings.ToLower(feedUrl.Scheme) == "mailto"
+}
+
+func decodeMimeHeader(s string) string {
+	decoded, err := mimeWordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func decodeTransferEncoding(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+func decodeCharset(data []byte, charset string) []byte {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return data
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		util.LogWarn(err)
+		return data
+	}
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		util.LogWarn(err)
+		return data
+	}
+	return decoded
+}
+
+func collectEmailParts(header map[string][]string, body io.Reader, parts *emailParts) error {
+	getHeader := func(key string) string {
+		values := header[key]
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+
+	contentType := getHeader("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mimeType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mimeType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mimeType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = collectEmailParts(part.Header, part, parts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransferEncoding(body, getHeader("Content-Transfer-Encoding")))
+	if err != nil {
+		return err
+	}
+
+	disposition, _, _ := mime.ParseMediaType(getHeader("Content-Disposition"))
+	part := &emailPart{
+		mimeType:  mimeType,
+		contentId: strings.Trim(getHeader("Content-Id"), "<> "),
+		body:      data,
+	}
+
+	switch {
+	case mimeType == "text/html" && disposition != "attachment" && parts.html == nil:
+		part.body = decodeCharset(data, params["charset"])
+		parts.html = part
+
+	case mimeType == "text/plain" && disposition != "attachment" && parts.text == nil:
+		part.body = decodeCharset(data, params["charset"])
+		parts.text = part
+
+	case strings.HasPrefix(mimeType, "image/") && part.contentId != "":
+		parts.inline = append(parts.inline, part)
+	}
+
+	return nil
+}
+
+func plainTextToHtml(text string) string {
+	var sb strings.Builder
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		sb.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br/>") + "</p>")
+	}
+	return sb.String()
+}
+
+// Returns the first HTTP(S) link from List-Unsubscribe, or the mailto: link if there's no HTTP one.
+func emailUnsubscribeLink(header string) string {
+	mailtoLink := ""
+	for _, entry := range strings.Split(header, ",") {
+		link := strings.Trim(strings.TrimSpace(entry), "<>")
+		linkUrl, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		if IsHttp(*linkUrl) {
+			return link
+		}
+		if IsEmail(*linkUrl) && mailtoLink == "" {
+			mailtoLink = link
+		}
+	}
+	return mailtoLink
+}
+
+// Replaces the attribute values that are exactly "cid:<contentId>" (quoted or not),
+// so that e.g. cid:img1 doesn't match the beginning of cid:img10.
+func replaceCidUrl(content string, contentId string, newUrl string) string {
+	rx := regexp.MustCompile(`(=\s*)(["']?)(?i:cid:)` + regexp.QuoteMeta(contentId) + `(["'\s>/]|$)`)
+	return rx.ReplaceAllStringFunc(content, func(match string) string {
+		parts := rx.FindStringSubmatch(match)
+		if parts[2] != "" && parts[3] != parts[2] {
+			// the closing quote must match the opening one
+			return match
+		}
+		return parts[1] + parts[2] + newUrl + parts[3]
+	})
+}
+
+// Converts a raw email message (RFC 5322) to a feed item.
+// The HTML part is preferred over the plain text one,
+// and inline images (cid: URLs) are embedded as data: URLs.
+func EmailToFeedItem(data []byte) (*gofeed.Item, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := &emailParts{}
+	err = collectEmailParts(msg.Header, msg.Body, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	content := ""
+	if parts.html != nil {
+		content = string(parts.html.body)
+		for _, inline := range parts.inline {
+			if len(inline.body) > emailMaxInlineImageSize {
+				continue
+			}
+			dataUrl := "data:" + inline.mimeType + ";base64," + base64.StdEncoding.EncodeToString(inline.body)
+			content = replaceCidUrl(content, inline.contentId, dataUrl)
+		}
+	} else if parts.text != nil {
+		content = plainTextToHtml(string(parts.text.body))
+	} else {
+		return nil, errors.New("the message has neither HTML nor text part")
+	}
+
+	unsubscribeLink := emailUnsubscribeLink(msg.Header.Get("List-Unsubscribe"))
+	if unsubscribeLink != "" {
+		content += fmt.Sprintf(`<p><a href="%s">Unsubscribe</a></p>`, html.EscapeString(unsubscribeLink))
+	}
+
+	messageId := strings.Trim(msg.Header.Get("Message-Id"), "<> ")
+	if messageId == "" {
+		messageId = fmt.Sprintf("%d@feedmash", time.Now().UnixNano())
+	}
+	// RFC 2392 message URL, since newsletters don't have a link to a web page in a standard way
+	link := "mid:" + url.PathEscape(messageId)
+
+	item := &gofeed.Item{
+		Title:   decodeMimeHeader(msg.Header.Get("Subject")),
+		Link:    link,
+		GUID:    link,
+		Content: content,
+	}
+
+	date, err := msg.Header.Date()
+	if err == nil {
+		item.PublishedParsed = &date
+	}
+
+	fromList, err := msg.Header.AddressList("From")
+	if err == nil && len(fromList) > 0 {
+		item.Authors = []*gofeed.Person{{
+			Name:  fromList[0].Name,
+			Email: fromList[0].Address,
+		}}
+	}
+
+	return item, nil
+}
+
+// Email sources don't have anything to download, the items are pushed by the SMTP server.
+var EmailSourceFuncs = FeedTypeFuncs{
+	RealUrl: func(feedUrl url.URL) string {
+		return feedUrl.String()
+	},
+	LoadFeed: func(realUrl string, _ string) (*gofeed.Feed, error) {
+		return nil, errors.New(realUrl + ": email sources can't be downloaded")
+	},
+	SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+}
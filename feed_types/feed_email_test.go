@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import "testing"
+
+func TestReplaceCidUrl(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{`<img src="cid:img1">`, `<img src="data:x">`},
+		{`<img src='cid:img1'/>`, `<img src='data:x'/>`},
+		{`<img src=cid:img1>`, `<img src=data:x>`},
+		{`<img src = "CID:img1" alt="">`, `<img src = "data:x" alt="">`},
+		{`<img src="cid:img10">`, `<img src="cid:img10">`},
+		{`<img src="cid:img1.png">`, `<img src="cid:img1.png">`},
+		{`<p>see cid:img1</p>`, `<p>see cid:img1</p>`},
+		{`<img src="cid:img1"><img src="cid:img1">`, `<img src="data:x"><img src="data:x">`},
+	}
+
+	for _, test := range tests {
+		got := replaceCidUrl(test.content, "img1", "data:x")
+		if got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.content, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const geminiDefaultPort = "1965"
+const geminiTimeout = 30 * time.Second
+const geminiMaxRedirects = 5
+const geminiMaxBodySize = 10 * 1024 * 1024
+const geminiKnownHostsStateKey = "gemini-known-hosts"
+
+// "=> gemini://example.org/post.gmi 2024-05-01 Post title" or "=> post.gmi 2024-05-01 - Post title"
+var gemlogLinkRx = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})\s*[-–—:]?\s*(.*)$`)
+
+// Trust on first use: the certificate of each host is remembered on the first connection,
+// and a different certificate is only accepted after the remembered one expires.
+type geminiKnownHost struct {
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+var geminiKnownHostsMutex sync.Mutex
+
+type geminiResponse struct {
+	url      *url.URL
+	mimeType string
+	body     []byte
+}
+
+type geminiConfig struct {
+	maxContent int
+}
+
+func IsGemini(feedUrl url.URL) bool {
+	return strings.ToLower(feedUrl.Scheme) == "gemini"
+}
+
+func GeminiRealUrl(feedUrl url.URL) string {
+	return feedUrl.String()
+}
+
+func verifyGeminiCert(hostPort string, leafDer []byte, notAfter time.Time) error {
+	hash := sha256.Sum256(leafDer)
+	fingerprint := hex.EncodeToString(hash[:])
+
+	geminiKnownHostsMutex.Lock()
+	defer geminiKnownHostsMutex.Unlock()
+
+	knownHosts := map[string]geminiKnownHost{}
+	util.LoadState(geminiKnownHostsStateKey, &knownHosts)
+
+	knownHost, isKnown := knownHosts[hostPort]
+	if isKnown && knownHost.Fingerprint == fingerprint {
+		return nil
+	}
+	if isKnown && time.Now().Before(knownHost.NotAfter) {
+		return fmt.Errorf(
+			"%s: the certificate has changed (the new fingerprint is %s); "+
+				"if that's expected, remove this host from %s.json in the state directory",
+			hostPort, fingerprint, geminiKnownHostsStateKey,
+		)
+	}
+
+	if isKnown {
+		util.LogInfo(fmt.Sprintf("%s: the certificate has expired, trusting the new one", hostPort))
+	}
+	knownHosts[hostPort] = geminiKnownHost{
+		Fingerprint: fingerprint,
+		NotAfter:    notAfter,
+	}
+	util.SaveState(geminiKnownHostsStateKey, knownHosts)
+	return nil
+}
+
+func geminiRequest(reqUrl *url.URL) (*geminiResponse, error) {
+	hostPort := reqUrl.Host
+	if reqUrl.Port() == "" {
+		hostPort = net.JoinHostPort(reqUrl.Hostname(), geminiDefaultPort)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: reqUrl.Hostname(),
+		MinVersion: tls.VersionTLS12,
+		// self-signed certificates are the norm in Gemini, so they're checked with TOFU instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New(hostPort + ": no certificate")
+			}
+			leaf := state.PeerCertificates[0]
+			return verifyGeminiCert(hostPort, leaf.Raw, leaf.NotAfter)
+		},
+	}
+
+	dialer := &net.Dialer{Timeout: geminiTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostPort, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			util.LogWarn(err)
+		}
+	}()
+
+	err = conn.SetDeadline(time.Now().Add(geminiTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write([]byte(reqUrl.String() + "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	header = strings.TrimRight(header, "\r\n")
+	if len(header) < 2 {
+		return nil, fmt.Errorf("%s: invalid response header \"%s\"", reqUrl.String(), header)
+	}
+	status := header[:2]
+	meta := strings.TrimSpace(header[2:])
+
+	switch status[0] {
+	case '2':
+		body, err := io.ReadAll(io.LimitReader(reader, geminiMaxBodySize))
+		if err != nil {
+			return nil, err
+		}
+		mimeType, _, err := mime.ParseMediaType(meta)
+		if err != nil || meta == "" {
+			mimeType = "text/gemini"
+		}
+		return &geminiResponse{
+			url:      reqUrl,
+			mimeType: mimeType,
+			body:     body,
+		}, nil
+
+	case '3':
+		return nil, &geminiRedirect{target: meta}
+
+	default:
+		return nil, fmt.Errorf("%s: status %s %s", reqUrl.String(), status, meta)
+	}
+}
+
+type geminiRedirect struct {
+	target string
+}
+
+func (r *geminiRedirect) Error() string {
+	return "redirect to " + r.target
+}
+
+func geminiGet(urlStr string) (*geminiResponse, error) {
+	reqUrl, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		resp, err := geminiRequest(reqUrl)
+		var redirect *geminiRedirect
+		if !errors.As(err, &redirect) {
+			return resp, err
+		}
+		if i >= geminiMaxRedirects {
+			return nil, fmt.Errorf("%s: too many redirects", urlStr)
+		}
+
+		targetUrl, err := url.Parse(redirect.target)
+		if err != nil {
+			return nil, err
+		}
+		targetUrl = reqUrl.ResolveReference(targetUrl)
+		// the certificate checks and the response parsing only apply to Gemini
+		if !IsGemini(*targetUrl) {
+			return nil, fmt.Errorf("%s: redirect to a non-Gemini URL %s", reqUrl.String(), targetUrl.String())
+		}
+		reqUrl = targetUrl
+	}
+}
+
+// Returns the link URL and the description of a "=>" line.
+func parseGemtextLink(line string) (string, string) {
+	fields := strings.Fields(strings.TrimPrefix(line, "=>"))
+	if len(fields) == 0 {
+		return "", ""
+	}
+	return fields[0], strings.Join(fields[1:], " ")
+}
+
+func GemtextToHtml(gemtext string, baseUrl *url.URL) string {
+	var sb strings.Builder
+	isPre := false
+	isList := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(gemtext, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(line, "```") {
+			if isPre {
+				sb.WriteString("</pre>")
+			} else {
+				if isList {
+					sb.WriteString("</ul>")
+					isList = false
+				}
+				sb.WriteString("<pre>")
+			}
+			isPre = !isPre
+			continue
+		}
+		if isPre {
+			sb.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		if strings.HasPrefix(line, "* ") {
+			if !isList {
+				sb.WriteString("<ul>")
+				isList = true
+			}
+			sb.WriteString("<li>" + html.EscapeString(strings.TrimSpace(line[2:])) + "</li>")
+			continue
+		}
+		if isList {
+			sb.WriteString("</ul>")
+			isList = false
+		}
+
+		switch {
+		case strings.HasPrefix(line, "=>"):
+			link, text := parseGemtextLink(line)
+			if link == "" {
+				continue
+			}
+			link = resolveUrl(baseUrl, link)
+			if text == "" {
+				text = link
+			}
+			sb.WriteString(`<p><a href="` + html.EscapeString(link) + `">` + html.EscapeString(text) + "</a></p>")
+
+		case strings.HasPrefix(line, "###"):
+			sb.WriteString("<h3>" + html.EscapeString(strings.TrimSpace(line[3:])) + "</h3>")
+
+		case strings.HasPrefix(line, "##"):
+			sb.WriteString("<h2>" + html.EscapeString(strings.TrimSpace(line[2:])) + "</h2>")
+
+		case strings.HasPrefix(line, "#"):
+			sb.WriteString("<h1>" + html.EscapeString(strings.TrimSpace(line[1:])) + "</h1>")
+
+		case strings.HasPrefix(line, ">"):
+			sb.WriteString("<blockquote>" + html.EscapeString(strings.TrimSpace(line[1:])) + "</blockquote>")
+
+		case strings.TrimSpace(line) == "":
+			continue
+
+		default:
+			sb.WriteString("<p>" + html.EscapeString(line) + "</p>")
+		}
+	}
+
+	if isPre {
+		sb.WriteString("</pre>")
+	}
+	if isList {
+		sb.WriteString("</ul>")
+	}
+	return sb.String()
+}
+
+// The title of a gemtext page is its first heading.
+func gemtextTitle(gemtext string) string {
+	for _, line := range strings.Split(gemtext, "\n") {
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+	}
+	return ""
+}
+
+func (cfg geminiConfig) gemlogFeed(resp *geminiResponse) *gofeed.Feed {
+	gemtext := string(resp.body)
+	feed := &gofeed.Feed{
+		Title: gemtextTitle(gemtext),
+		Link:  resp.url.String(),
+		Items: []*gofeed.Item{},
+	}
+
+	for _, line := range strings.Split(gemtext, "\n") {
+		if !strings.HasPrefix(line, "=>") {
+			continue
+		}
+		link, text := parseGemtextLink(strings.TrimSpace(line))
+		matches := gemlogLinkRx.FindStringSubmatch(text)
+		if link == "" || matches == nil {
+			continue
+		}
+
+		published := parseDate(matches[1], "2006-01-02")
+		if published == nil {
+			continue
+		}
+		feed.Items = append(feed.Items, &gofeed.Item{
+			Title:           strings.TrimSpace(matches[2]),
+			Link:            resolveUrl(resp.url, link),
+			PublishedParsed: published,
+		})
+	}
+
+	// gemlog indexes have no content, so download the newest posts
+	nContent := 0
+	for _, item := range newestGofeedItems(feed.Items) {
+		if nContent >= cfg.maxContent {
+			break
+		}
+		nContent++
+
+		itemUrl, err := url.Parse(item.Link)
+		if err != nil || !IsGemini(*itemUrl) {
+			continue
+		}
+		itemResp, err := geminiGet(item.Link)
+		if err != nil {
+			util.LogWarn(err)
+			continue
+		}
+		if itemResp.mimeType == "text/gemini" {
+			item.Content = GemtextToHtml(string(itemResp.body), itemResp.url)
+		} else if strings.HasPrefix(itemResp.mimeType, "text/") {
+			item.Content = "<pre>" + html.EscapeString(string(itemResp.body)) + "</pre>"
+		}
+	}
+
+	return feed
+}
+
+func newestGofeedItems(items []*gofeed.Item) []*gofeed.Item {
+	sorted := make([]*gofeed.Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].PublishedParsed.After(*sorted[b].PublishedParsed)
+	})
+	return sorted
+}
+
+func (cfg geminiConfig) loadFeed(realUrl string, _ string) (*gofeed.Feed, error) {
+	resp, err := geminiGet(realUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.mimeType == "text/gemini" {
+		feed := cfg.gemlogFeed(resp)
+		if len(feed.Items) == 0 {
+			return nil, fmt.Errorf("%s: no gemlog entries found", realUrl)
+		}
+		return feed, nil
+	}
+
+	// Atom/RSS served over Gemini
+	feed, err := NewFeedParser().Parse(bytes.NewReader(resp.body))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range feed.Items {
+		if item.Link != "" {
+			item.Link = resolveUrl(resp.url, item.Link)
+		}
+	}
+	return feed, nil
+}
+
+func NewGeminiSourceFuncs(options *viper.Viper) *FeedTypeFuncs {
+	options.SetDefault("maxContent", 10)
+	cfg := geminiConfig{
+		maxContent: options.GetInt("maxContent"),
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     GeminiRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs
+}
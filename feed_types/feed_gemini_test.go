@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"feedmash/util"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The pages of the test capsule: the path maps to the response header and body.
+var testCapsulePages = map[string][2]string{
+	"/gemlog/": {"20 text/gemini", strings.Join([]string{
+		"# Test gemlog",
+		"",
+		"Some text that is not an entry.",
+		"=> /about.gmi About",
+		"=> post1.gmi 2024-05-01 First post",
+		"=> post2.gmi 2024-05-03 - Second post",
+		"=> https://example.com/elsewhere 2024-05-02 Not on Gemini",
+		"",
+	}, "\r\n")},
+	"/gemlog/post1.gmi": {"20 text/gemini", "# First post\n\nHello from the *first* post.\n=> /gemlog/ Back\n"},
+	"/gemlog/post2.gmi": {"20 text/gemini", "# Second post\n\n* one\n* two\n"},
+	"/feed.atom": {"20 application/atom+xml", `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Test Atom feed</title>
+	<id>gemini://test/feed.atom</id>
+	<updated>2024-05-03T00:00:00Z</updated>
+	<entry>
+		<title>Atom entry</title>
+		<id>gemini://test/entry.gmi</id>
+		<link href="entry.gmi"/>
+		<updated>2024-05-03T00:00:00Z</updated>
+	</entry>
+</feed>`},
+	"/moved":         {"31 /gemlog/", ""},
+	"/to-https":      {"31 https://example.com/", ""},
+	"/not-found.gmi": {"51 Not found", ""},
+}
+
+func newTestCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// Serves testCapsulePages on addr until the test ends; returns the actual address.
+func startTestCapsule(t *testing.T, addr string, cert tls.Certificate) string {
+	listener, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				reqUrl, err := url.Parse(strings.TrimSpace(line))
+				if err != nil {
+					return
+				}
+				page, isFound := testCapsulePages[reqUrl.Path]
+				if !isFound {
+					page = [2]string{"51 Not found", ""}
+				}
+				_, _ = conn.Write([]byte(page[0] + "\r\n" + page[1]))
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func setTestStateDir(t *testing.T) {
+	oldStateDir := util.StateDir()
+	util.SetStateDir(t.TempDir())
+	t.Cleanup(func() {
+		util.SetStateDir(oldStateDir)
+	})
+}
+
+func TestGemlogIndex(t *testing.T) {
+	setTestStateDir(t)
+	addr := startTestCapsule(t, "127.0.0.1:0", newTestCert(t))
+
+	cfg := geminiConfig{maxContent: 1}
+	feed, err := cfg.loadFeed("gemini://"+addr+"/gemlog/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Title != "Test gemlog" {
+		t.Errorf("feed title: %q", feed.Title)
+	}
+	if len(feed.Items) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(feed.Items))
+	}
+
+	first := feed.Items[0]
+	if first.Title != "First post" || first.Link != "gemini://"+addr+"/gemlog/post1.gmi" {
+		t.Errorf("first entry: %q %q", first.Title, first.Link)
+	}
+	if first.PublishedParsed == nil || first.PublishedParsed.Format("2006-01-02") != "2024-05-01" {
+		t.Errorf("first entry date: %v", first.PublishedParsed)
+	}
+	// only the newest post is downloaded with maxContent: 1
+	if first.Content != "" {
+		t.Errorf("the older post must not be downloaded: %q", first.Content)
+	}
+
+	second := feed.Items[1]
+	if second.Title != "Second post" {
+		t.Errorf("second entry title: %q", second.Title)
+	}
+	if !strings.Contains(second.Content, "<li>one</li>") {
+		t.Errorf("second entry content: %q", second.Content)
+	}
+
+	if feed.Items[2].Link != "https://example.com/elsewhere" {
+		t.Errorf("absolute links must be kept: %q", feed.Items[2].Link)
+	}
+}
+
+func TestGeminiAtomFeed(t *testing.T) {
+	setTestStateDir(t)
+	addr := startTestCapsule(t, "127.0.0.1:0", newTestCert(t))
+
+	cfg := geminiConfig{maxContent: 10}
+	feed, err := cfg.loadFeed("gemini://"+addr+"/feed.atom", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Test Atom feed" || len(feed.Items) != 1 {
+		t.Fatalf("unexpected feed: %q, %d items", feed.Title, len(feed.Items))
+	}
+	if feed.Items[0].Link != "gemini://"+addr+"/entry.gmi" {
+		t.Errorf("relative links must be resolved: %q", feed.Items[0].Link)
+	}
+}
+
+func TestGeminiRedirects(t *testing.T) {
+	setTestStateDir(t)
+	addr := startTestCapsule(t, "127.0.0.1:0", newTestCert(t))
+
+	resp, err := geminiGet("gemini://" + addr + "/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.url.Path != "/gemlog/" || resp.mimeType != "text/gemini" {
+		t.Errorf("unexpected response: %s %s", resp.url, resp.mimeType)
+	}
+
+	_, err = geminiGet("gemini://" + addr + "/to-https")
+	if err == nil || !strings.Contains(err.Error(), "non-Gemini") {
+		t.Errorf("redirects to other schemes must fail, got %v", err)
+	}
+
+	_, err = geminiGet("gemini://" + addr + "/not-found.gmi")
+	if err == nil || !strings.Contains(err.Error(), "51") {
+		t.Errorf("error statuses must fail, got %v", err)
+	}
+}
+
+func TestGeminiTofu(t *testing.T) {
+	setTestStateDir(t)
+	cert := newTestCert(t)
+	addr := startTestCapsule(t, "127.0.0.1:0", cert)
+	pageUrl := "gemini://" + addr + "/gemlog/post1.gmi"
+
+	_, err := geminiGet(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := map[string]geminiKnownHost{}
+	if !util.LoadState(geminiKnownHostsStateKey, &knownHosts) || knownHosts[addr].Fingerprint == "" {
+		t.Fatalf("the certificate must be pinned: %v", knownHosts)
+	}
+
+	// the same certificate is accepted again
+	_, err = geminiGet(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a different certificate is rejected while the pinned one is still valid:
+	// the other capsule has its own certificate, but the first one is pinned for it
+	otherAddr := startTestCapsule(t, "127.0.0.1:0", newTestCert(t))
+	knownHosts[otherAddr] = knownHosts[addr]
+	util.SaveState(geminiKnownHostsStateKey, knownHosts)
+	_, err = geminiGet("gemini://" + otherAddr + "/gemlog/post1.gmi")
+	if err == nil || !strings.Contains(err.Error(), "certificate has changed") {
+		t.Fatalf("a changed certificate must be rejected, got %v", err)
+	}
+
+	// the new certificate is trusted after the pinned one expires
+	expiredHost := knownHosts[otherAddr]
+	expiredHost.NotAfter = time.Now().Add(-time.Hour)
+	knownHosts[otherAddr] = expiredHost
+	util.SaveState(geminiKnownHostsStateKey, knownHosts)
+	_, err = geminiGet("gemini://" + otherAddr + "/gemlog/post1.gmi")
+	if err != nil {
+		t.Fatalf("a new certificate must be trusted after the old one expires, got %v", err)
+	}
+}
@@ -4,10 +4,13 @@
 package feed_types
 
 import (
+	"bytes"
 	"feedmash/util"
 	"fmt"
 	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
+	"io"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -26,13 +29,109 @@ func HttpRealUrl(feedUrl url.URL) string {
 	return feedUrl.String()
 }
 
-func HttpSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
-	if item.Link == "" {
-		util.LogWarn(fmt.Sprintf("Item \"%s\" has no link", item.Title))
+func httpGet(urlStr string, userAgent string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		closeBody(resp.Body)
+		return nil, fmt.Errorf("%s: HTTP %s", urlStr, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func closeBody(body io.ReadCloser) {
+	err := body.Close()
+	if err != nil {
+		util.LogWarn(err)
+	}
+}
+
+// Used when the date format is not specified.
+var commonDateFormats = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+func resolveUrl(baseUrl *url.URL, ref string) string {
+	refUrl, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return baseUrl.ResolveReference(refUrl).String()
+}
+
+func parseDate(s string, dateFormat string) *time.Time {
+	if s == "" {
 		return nil
 	}
 
+	formats := commonDateFormats
+	if dateFormat != "" {
+		formats = []string{dateFormat}
+	}
+
+	for _, format := range formats {
+		t, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func HttpLoadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	resp, err := httpGet(realUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := NewFeedParser().Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	xmlBase := feedXmlBase(data)
+	if xmlBase != "" {
+		if feed.Custom == nil {
+			feed.Custom = map[string]string{}
+		}
+		feed.Custom[xmlBaseCustomKey] = xmlBase
+	}
+	return feed, nil
+}
+
+// The items without links are kept (see FillMissingLinks).
+func HttpSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 	guid := item.GUID
+	if guid == "" && item.Link == "" {
+		guid = contentHashGuid(item)
+	}
 	if guid == "" {
 		guid = item.Link
 		if item.PublishedParsed != nil {
@@ -50,6 +149,9 @@ func HttpSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 	}
 
 	published := item.PublishedParsed
+	if published == nil {
+		published = item.UpdatedParsed
+	}
 	if published == nil {
 		now := time.Now()
 		published = &now
@@ -63,15 +165,39 @@ func HttpSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 		}
 	}
 
-	outItem := feeds.Item{
-		Id:    guid,
-		Title: item.Title,
-		Link: &feeds.Link{
+	var link *feeds.Link = nil
+	if item.Link != "" {
+		link = &feeds.Link{
 			Href: item.Link,
-		},
+		}
+	}
+
+	var enclosure *feeds.Enclosure = nil
+	for _, itemEnclosure := range item.Enclosures {
+		if itemEnclosure.URL != "" {
+			enclosure = &feeds.Enclosure{
+				Url:    itemEnclosure.URL,
+				Length: itemEnclosure.Length,
+				Type:   itemEnclosure.Type,
+			}
+			break
+		}
+	}
+
+	var updated time.Time
+	if item.UpdatedParsed != nil && item.UpdatedParsed.After(*published) {
+		updated = item.UpdatedParsed.Local()
+	}
+
+	outItem := feeds.Item{
+		Id:          guid,
+		Title:       item.Title,
+		Link:        link,
 		Description: description,
 		Author:      author,
 		Created:     published.Local(),
+		Updated:     updated,
+		Enclosure:   enclosure,
 		Content:     content,
 	}
 	return &outItem
@@ -79,5 +205,6 @@ func HttpSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 
 var httpSourceFuncs = FeedTypeFuncs{
 	RealUrl:                     HttpRealUrl,
+	LoadFeed:                    HttpLoadFeed,
 	SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
 }
@@ -0,0 +1,565 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bufio"
+	"feedmash/util"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protects from endless or huge recurrences.
+const icsMaxIterations = 10000
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+type icsEvent struct {
+	uid          string
+	summary      string
+	description  string
+	location     string
+	link         string
+	start        time.Time
+	end          time.Time
+	isAllDay     bool
+	rrule        string
+	exdates      map[int64]bool
+	recurrenceId *time.Time
+	isCancelled  bool
+}
+
+type icsOccurrence struct {
+	event *icsEvent
+	start time.Time
+	end   time.Time
+}
+
+type icsConfig struct {
+	lookahead time.Duration
+	leadTime  time.Duration
+}
+
+func IsIcs(feedUrl url.URL) bool {
+	scheme := strings.ToLower(feedUrl.Scheme)
+	if scheme == "webcal" || scheme == "webcals" {
+		return true
+	}
+	return IsHttp(feedUrl) && strings.HasSuffix(strings.ToLower(feedUrl.Path), ".ics")
+}
+
+// webcal:// is just https:// for calendar apps.
+func IcsRealUrl(feedUrl url.URL) string {
+	scheme := strings.ToLower(feedUrl.Scheme)
+	if scheme == "webcal" || scheme == "webcals" {
+		feedUrl.Scheme = "https"
+	}
+	return feedUrl.String()
+}
+
+// Joins the folded lines (the ones that start with a space or a tab continue the previous line).
+func unfoldIcsLines(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func parseIcsProperty(line string) icsProperty {
+	prop := icsProperty{params: map[string]string{}}
+
+	// the value starts after the first colon that is not inside a quoted parameter value
+	isQuoted := false
+	colonPos := -1
+	for i, c := range line {
+		if c == '"' {
+			isQuoted = !isQuoted
+		} else if c == ':' && !isQuoted {
+			colonPos = i
+			break
+		}
+	}
+	if colonPos < 0 {
+		prop.name = strings.ToUpper(line)
+		return prop
+	}
+
+	prop.value = line[colonPos+1:]
+	parts := strings.Split(line[:colonPos], ";")
+	prop.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return prop
+}
+
+func unescapeIcsText(s string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(s)
+}
+
+// Returns the time and whether it's a date without time.
+func parseIcsTime(prop icsProperty) (time.Time, bool, error) {
+	loc := time.Local
+	tzid := prop.params["TZID"]
+	if tzid != "" {
+		tzLoc, err := time.LoadLocation(tzid)
+		if err == nil {
+			loc = tzLoc
+		}
+	}
+
+	value := strings.TrimSpace(prop.value)
+	if prop.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// Supports the most common subset of RFC 5545 durations, e.g. "PT1H30M" or "P1D".
+func parseIcsDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	s = strings.TrimLeft(s, "+-")
+	s = strings.TrimPrefix(s, "P")
+
+	var total time.Duration
+	num := ""
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			num += string(c)
+			continue
+		}
+		n, _ := strconv.Atoi(num)
+		num = ""
+		switch c {
+		case 'W':
+			total += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			total += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			total += time.Duration(n) * time.Hour
+		case 'M':
+			total += time.Duration(n) * time.Minute
+		case 'S':
+			total += time.Duration(n) * time.Second
+		}
+	}
+	return sign * total
+}
+
+func parseIcsEvents(lines []string) []*icsEvent {
+	var events []*icsEvent
+	var event *icsEvent
+	var duration *time.Duration
+	depth := 0 // nested components inside VEVENT, e.g. VALARM
+
+	for _, line := range lines {
+		prop := parseIcsProperty(line)
+
+		if prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT") {
+			event = &icsEvent{exdates: map[int64]bool{}}
+			duration = nil
+			depth = 0
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		if prop.name == "BEGIN" {
+			depth++
+			continue
+		}
+		if prop.name == "END" {
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if event.end.IsZero() {
+				switch {
+				case duration != nil:
+					event.end = event.start.Add(*duration)
+				case event.isAllDay:
+					event.end = event.start.AddDate(0, 0, 1)
+				default:
+					event.end = event.start
+				}
+			}
+			if !event.start.IsZero() {
+				events = append(events, event)
+			}
+			event = nil
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+
+		switch prop.name {
+		case "UID":
+			event.uid = prop.value
+		case "SUMMARY":
+			event.summary = unescapeIcsText(prop.value)
+		case "DESCRIPTION":
+			event.description = unescapeIcsText(prop.value)
+		case "LOCATION":
+			event.location = unescapeIcsText(prop.value)
+		case "URL":
+			event.link = prop.value
+		case "STATUS":
+			event.isCancelled = strings.EqualFold(prop.value, "CANCELLED")
+		case "RRULE":
+			event.rrule = prop.value
+		case "DTSTART":
+			t, isAllDay, err := parseIcsTime(prop)
+			if err == nil {
+				event.start = t
+				event.isAllDay = isAllDay
+			}
+		case "DTEND":
+			t, _, err := parseIcsTime(prop)
+			if err == nil {
+				event.end = t
+			}
+		case "DURATION":
+			d := parseIcsDuration(prop.value)
+			duration = &d
+		case "RECURRENCE-ID":
+			t, _, err := parseIcsTime(prop)
+			if err == nil {
+				event.recurrenceId = &t
+			}
+		case "EXDATE":
+			for _, val := range strings.Split(prop.value, ",") {
+				t, _, err := parseIcsTime(icsProperty{params: prop.params, value: val})
+				if err == nil {
+					event.exdates[t.Unix()] = true
+				}
+			}
+		}
+	}
+
+	return events
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// BYDAY entry, e.g. "MO", "1MO" (first Monday) or "-1FR" (last Friday).
+type icsByDay struct {
+	weekday time.Weekday
+	n       int
+}
+
+func parseIcsByDay(s string) []icsByDay {
+	var days []icsByDay
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) < 2 {
+			continue
+		}
+		weekday, ok := icsWeekdays[strings.ToUpper(entry[len(entry)-2:])]
+		if !ok {
+			continue
+		}
+		n, _ := strconv.Atoi(entry[:len(entry)-2])
+		days = append(days, icsByDay{weekday: weekday, n: n})
+	}
+	return days
+}
+
+func withDate(t time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+}
+
+// Candidate start times for one period (a day, a week, a month or a year) of the recurrence.
+func icsPeriodStarts(freq string, periodStart time.Time, byDays []icsByDay, byMonthDays []int) []time.Time {
+	var starts []time.Time
+
+	switch freq {
+	case "WEEKLY":
+		if len(byDays) == 0 {
+			return []time.Time{periodStart}
+		}
+		// the week starts on Monday
+		offset := (int(periodStart.Weekday()) + 6) % 7
+		weekStart := withDate(periodStart, periodStart.Year(), periodStart.Month(), periodStart.Day()-offset)
+		for _, byDay := range byDays {
+			dayOffset := (int(byDay.weekday) + 6) % 7
+			starts = append(starts, withDate(weekStart, weekStart.Year(), weekStart.Month(), weekStart.Day()+dayOffset))
+		}
+
+	case "MONTHLY":
+		year, month := periodStart.Year(), periodStart.Month()
+		daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		for _, day := range byMonthDays {
+			if day < 0 {
+				day += daysInMonth + 1
+			}
+			if day >= 1 && day <= daysInMonth {
+				starts = append(starts, withDate(periodStart, year, month, day))
+			}
+		}
+		for _, byDay := range byDays {
+			var matching []time.Time
+			for day := 1; day <= daysInMonth; day++ {
+				t := withDate(periodStart, year, month, day)
+				if t.Weekday() == byDay.weekday {
+					matching = append(matching, t)
+				}
+			}
+			switch {
+			case byDay.n == 0:
+				starts = append(starts, matching...)
+			case byDay.n > 0 && byDay.n <= len(matching):
+				starts = append(starts, matching[byDay.n-1])
+			case byDay.n < 0 && -byDay.n <= len(matching):
+				starts = append(starts, matching[len(matching)+byDay.n])
+			}
+		}
+
+	default:
+		starts = append(starts, periodStart)
+	}
+
+	sort.Slice(starts, func(a, b int) bool {
+		return starts[a].Before(starts[b])
+	})
+	return starts
+}
+
+// Expands the recurrence rule into the occurrences that end after windowStart and start before windowEnd.
+func (event *icsEvent) occurrences(windowStart time.Time, windowEnd time.Time) []icsOccurrence {
+	duration := event.end.Sub(event.start)
+	var result []icsOccurrence
+	add := func(start time.Time) {
+		end := start.Add(duration)
+		if event.exdates[start.Unix()] || !end.After(windowStart) || start.After(windowEnd) {
+			return
+		}
+		result = append(result, icsOccurrence{event: event, start: start, end: end})
+	}
+
+	if event.rrule == "" {
+		add(event.start)
+		return result
+	}
+
+	rule := map[string]string{}
+	for _, part := range strings.Split(event.rrule, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		rule[strings.ToUpper(key)] = val
+	}
+
+	freq := strings.ToUpper(rule["FREQ"])
+	interval, err := strconv.Atoi(rule["INTERVAL"])
+	if err != nil || interval < 1 {
+		interval = 1
+	}
+	count, _ := strconv.Atoi(rule["COUNT"])
+	until := windowEnd
+	if rule["UNTIL"] != "" {
+		t, _, err := parseIcsTime(icsProperty{params: map[string]string{"TZID": event.start.Location().String()}, value: rule["UNTIL"]})
+		if err == nil && t.Before(until) {
+			until = t
+		}
+	}
+	byDays := parseIcsByDay(rule["BYDAY"])
+	var byMonthDays []int
+	for _, s := range strings.Split(rule["BYMONTHDAY"], ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(s))
+		if err == nil {
+			byMonthDays = append(byMonthDays, day)
+		}
+	}
+
+	// without COUNT the periods before the window can be skipped right away
+	firstPeriod := 0
+	if count == 0 && (freq == "DAILY" || freq == "WEEKLY") {
+		periodDays := interval
+		if freq == "WEEKLY" {
+			periodDays *= 7
+		}
+		nDays := int(windowStart.Sub(event.start).Hours()/24) - int(duration.Hours()/24)
+		firstPeriod = max(0, nDays/periodDays-1)
+	}
+
+	nGenerated := 0
+	for i := firstPeriod; i < firstPeriod+icsMaxIterations; i++ {
+		var periodStart time.Time
+		switch freq {
+		case "DAILY":
+			periodStart = event.start.AddDate(0, 0, i*interval)
+		case "WEEKLY":
+			periodStart = event.start.AddDate(0, 0, 7*i*interval)
+		case "MONTHLY":
+			periodStart = withDate(event.start, event.start.Year(), event.start.Month()+time.Month(i*interval), 1)
+			if len(byDays) == 0 && len(byMonthDays) == 0 {
+				byMonthDays = []int{event.start.Day()}
+			}
+		case "YEARLY":
+			periodStart = event.start.AddDate(i*interval, 0, 0)
+		default:
+			util.LogWarn(fmt.Sprintf("%s: unsupported recurrence rule \"%s\"", event.summary, event.rrule))
+			add(event.start)
+			return result
+		}
+		if periodStart.After(until) && !(freq == "WEEKLY" && len(byDays) > 0) {
+			break
+		}
+
+		for _, start := range icsPeriodStarts(freq, periodStart, byDays, byMonthDays) {
+			if start.Before(event.start) {
+				continue
+			}
+			if start.After(until) || (count > 0 && nGenerated >= count) {
+				return result
+			}
+			nGenerated++
+			add(start)
+		}
+	}
+
+	return result
+}
+
+func icsOccurrenceContent(occ icsOccurrence) string {
+	var sb strings.Builder
+	timeFormat := "Mon, 02 Jan 2006 15:04 MST"
+	end := occ.end
+	if occ.event.isAllDay {
+		timeFormat = "Mon, 02 Jan 2006"
+		end = end.AddDate(0, 0, -1)
+	}
+
+	sb.WriteString("<p><b>Start:</b> " + html.EscapeString(occ.start.Format(timeFormat)) + "<br/>")
+	sb.WriteString("<b>End:</b> " + html.EscapeString(end.Format(timeFormat)))
+	if occ.event.location != "" {
+		sb.WriteString("<br/><b>Location:</b> " + html.EscapeString(occ.event.location))
+	}
+	sb.WriteString("</p>")
+	if occ.event.description != "" {
+		sb.WriteString(plainTextToHtml(occ.event.description))
+	}
+	return sb.String()
+}
+
+func (cfg icsConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	resp, err := httpGet(realUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	lines, err := unfoldIcsLines(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	events := parseIcsEvents(lines)
+
+	calName := realUrl
+	for _, line := range lines {
+		prop := parseIcsProperty(line)
+		if prop.name == "X-WR-CALNAME" {
+			calName = unescapeIcsText(prop.value)
+			break
+		}
+	}
+
+	now := time.Now()
+	windowEnd := now.Add(cfg.lookahead)
+
+	// modified instances of recurring events replace the generated ones
+	overrides := map[string]bool{}
+	for _, event := range events {
+		if event.recurrenceId != nil {
+			overrides[event.uid+"\n"+strconv.FormatInt(event.recurrenceId.Unix(), 10)] = true
+		}
+	}
+
+	feed := &gofeed.Feed{
+		Title: calName,
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+
+	for _, event := range events {
+		if event.isCancelled {
+			continue
+		}
+		for _, occ := range event.occurrences(now, windowEnd) {
+			if event.recurrenceId == nil && overrides[event.uid+"\n"+strconv.FormatInt(occ.start.Unix(), 10)] {
+				continue
+			}
+
+			// the event shows up in the feed only when its lead time comes
+			published := occ.start.Add(-cfg.leadTime)
+			if published.After(now) {
+				continue
+			}
+
+			link := event.link
+			if link == "" {
+				link = realUrl
+			}
+			feed.Items = append(feed.Items, &gofeed.Item{
+				Title:           event.summary,
+				Link:            link,
+				GUID:            event.uid + "/" + occ.start.UTC().Format("20060102T150405Z"),
+				Content:         icsOccurrenceContent(occ),
+				PublishedParsed: &published,
+			})
+		}
+	}
+
+	return feed, nil
+}
+
+func NewIcsSourceFuncs(options *viper.Viper) *FeedTypeFuncs {
+	options.SetDefault("lookaheadDays", 30)
+	options.SetDefault("leadTime", "24h")
+	cfg := icsConfig{
+		lookahead: time.Duration(options.GetInt("lookaheadDays")) * 24 * time.Hour,
+		leadTime:  options.GetDuration("leadTime"),
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     IcsRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs
+}
@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"encoding/json"
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One step of a JSONPath-like expression:
+// a map key, an array index or a wildcard that selects all elements.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+const jsonUnixDateFormat = "unix"
+
+var (
+	jsonTimestampMin = time.Date(1995, 1, 1, 0, 0, 0, 0, time.UTC)
+	jsonTimestampMax = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// A subset of JSONPath, e.g. "$.data.builds[*]", "author.name", "tags[0]" or "['web-url']".
+// The leading "$" is optional.
+type jsonPath []jsonPathStep
+
+type jsonConfig struct {
+	items      jsonPath
+	id         jsonPath
+	title      jsonPath
+	link       jsonPath
+	date       jsonPath
+	dateFormat string
+	author     jsonPath
+	content    jsonPath
+}
+
+func parseJsonPath(expr string) (jsonPath, error) {
+	path := jsonPath{}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in \"%s\"", expr)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+
+			if inner == "*" {
+				path = append(path, jsonPathStep{wildcard: true})
+				continue
+			}
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, jsonPathStep{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index \"%s\" in \"%s\"", inner, expr)
+			}
+			path = append(path, jsonPathStep{index: index, isIndex: true})
+			continue
+		}
+
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		s = s[end:]
+		if key == "" {
+			return nil, fmt.Errorf("empty key in \"%s\"", expr)
+		}
+		if key == "*" {
+			path = append(path, jsonPathStep{wildcard: true})
+		} else {
+			path = append(path, jsonPathStep{key: key})
+		}
+	}
+
+	return path, nil
+}
+
+func (path jsonPath) eval(root interface{}) []interface{} {
+	values := []interface{}{root}
+
+	for _, step := range path {
+		var nextValues []interface{}
+		for _, val := range values {
+			switch node := val.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, child := range node {
+						nextValues = append(nextValues, child)
+					}
+				} else if child, ok := node[step.key]; ok && !step.isIndex {
+					nextValues = append(nextValues, child)
+				}
+
+			case []interface{}:
+				if step.wildcard {
+					nextValues = append(nextValues, node...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(node)
+					}
+					if index >= 0 && index < len(node) {
+						nextValues = append(nextValues, node[index])
+					}
+				}
+			}
+		}
+		values = nextValues
+	}
+
+	return values
+}
+
+func (path jsonPath) first(root interface{}) interface{} {
+	if path == nil {
+		return nil
+	}
+	values := path.eval(root)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func (path jsonPath) string(root interface{}) string {
+	switch val := path.first(root).(type) {
+	case nil:
+		return ""
+
+	case string:
+		return strings.TrimSpace(val)
+
+	case json.Number:
+		return val.String()
+
+	case bool:
+		return strconv.FormatBool(val)
+
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
+
+// With dateFormat: unix numbers (and numeric strings) are Unix timestamps in seconds or milliseconds.
+// Without dateFormat only the numbers that fall into jsonTimestampMin..jsonTimestampMax are timestamps,
+// so that other numbers (e.g. 20240501) are not turned into dates in 1970.
+func parseJsonDate(val interface{}, dateFormat string) *time.Time {
+	s := ""
+	switch v := val.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case json.Number:
+		s = v.String()
+	default:
+		return nil
+	}
+
+	if dateFormat == "" || dateFormat == jsonUnixDateFormat {
+		ts, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			if ts > 1e12 {
+				ts /= 1000
+			}
+			t := time.Unix(0, int64(ts*float64(time.Second)))
+			if dateFormat == jsonUnixDateFormat || (t.After(jsonTimestampMin) && t.Before(jsonTimestampMax)) {
+				return &t
+			}
+		}
+		if dateFormat == jsonUnixDateFormat {
+			return nil
+		}
+	}
+
+	return parseDate(s, dateFormat)
+}
+
+func (cfg jsonConfig) itemFromValue(val interface{}, baseUrl *url.URL) *gofeed.Item {
+	item := &gofeed.Item{
+		GUID:    cfg.id.string(val),
+		Title:   cfg.title.string(val),
+		Content: cfg.content.string(val),
+	}
+
+	link := cfg.link.string(val)
+	if link != "" {
+		item.Link = resolveUrl(baseUrl, link)
+	}
+
+	dateVal := cfg.date.first(val)
+	if dateVal != nil {
+		item.PublishedParsed = parseJsonDate(dateVal, cfg.dateFormat)
+		if item.PublishedParsed == nil {
+			util.LogWarn(fmt.Sprintf("%s: cannot parse date \"%v\"", baseUrl.String(), dateVal))
+		}
+	}
+
+	author := cfg.author.string(val)
+	if author != "" {
+		item.Authors = []*gofeed.Person{{Name: author}}
+	}
+
+	if item.Title == "" && item.Link == "" && item.Content == "" {
+		return nil
+	}
+
+	if item.Link == "" && item.GUID != "" {
+		// the item has no page of its own, so link to the API URL
+		item.Link = baseUrl.String()
+	}
+
+	return item
+}
+
+func (cfg jsonConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	baseUrl, err := url.Parse(realUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(realUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	var root interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+
+	values := cfg.items.eval(root)
+	if len(values) == 1 {
+		// allow "data.items" instead of "data.items[*]"
+		if arr, ok := values[0].([]interface{}); ok {
+			values = arr
+		}
+	}
+
+	feed := &gofeed.Feed{
+		Title: baseUrl.Host,
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+	for _, val := range values {
+		item := cfg.itemFromValue(val, baseUrl)
+		if item != nil {
+			feed.Items = append(feed.Items, item)
+		}
+	}
+
+	return feed, nil
+}
+
+func NewJsonSourceFuncs(options *viper.Viper) (*FeedTypeFuncs, error) {
+	cfg := jsonConfig{
+		dateFormat: options.GetString("dateFormat"),
+	}
+
+	fields := []struct {
+		key  string
+		path *jsonPath
+	}{
+		{"items", &cfg.items},
+		{"id", &cfg.id},
+		{"title", &cfg.title},
+		{"link", &cfg.link},
+		{"date", &cfg.date},
+		{"author", &cfg.author},
+		{"content", &cfg.content},
+	}
+	for _, field := range fields {
+		expr := options.GetString(field.key)
+		if expr == "" {
+			continue
+		}
+		path, err := parseJsonPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		*field.path = path
+	}
+
+	if cfg.items == nil {
+		return nil, errors.New("the \"items\" expression is required for the json type")
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     HttpRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs, nil
+}
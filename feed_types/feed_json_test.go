@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseJsonDate(t *testing.T) {
+	tests := []struct {
+		val        interface{}
+		dateFormat string
+		expected   string
+	}{
+		{json.Number("1714521600"), "", "2024-05-01"},
+		{json.Number("1714521600000"), "", "2024-05-01"},
+		{"1714521600", "", "2024-05-01"},
+		{"2024-05-01T00:00:00Z", "", "2024-05-01"},
+		{json.Number("42"), "", ""},
+		{"42", "", ""},
+		{json.Number("42"), "unix", "1970-01-01"},
+		{"2024-05-01", "unix", ""},
+		{"01.05.2024", "02.01.2006", "2024-05-01"},
+		{true, "", ""},
+	}
+
+	for _, test := range tests {
+		date := parseJsonDate(test.val, test.dateFormat)
+		got := ""
+		if date != nil {
+			got = date.UTC().Format(time.DateOnly)
+		}
+		if got != test.expected {
+			t.Errorf("%v (%q): got %q, expected %q", test.val, test.dateFormat, got, test.expected)
+		}
+	}
+}
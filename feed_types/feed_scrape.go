@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The attribute suffix of a selector; "@" elsewhere is a part of the selector itself,
+// e.g. in a[href^="mailto:"] or [data-x*="@"].
+var scrapeAttrRx = regexp.MustCompile(`\s*@\s*([\w-]+)$`)
+
+// A selector with an optional attribute name, e.g. "a.permalink@href".
+// Without the attribute the text of the element is used.
+type scrapeSelector struct {
+	selector string
+	attr     string
+}
+
+type scrapeConfig struct {
+	item       string
+	title      scrapeSelector
+	link       scrapeSelector
+	date       scrapeSelector
+	dateFormat string
+	content    string
+}
+
+func parseScrapeSelector(s string, defaultAttr string) scrapeSelector {
+	selector := strings.TrimSpace(s)
+	attr := defaultAttr
+	match := scrapeAttrRx.FindStringSubmatchIndex(selector)
+	if match != nil {
+		attr = selector[match[2]:match[3]]
+		selector = selector[:match[0]]
+	}
+	return scrapeSelector{
+		selector: selector,
+		attr:     attr,
+	}
+}
+
+// Returns the value of the first element that matches the selector.
+// An empty selector refers to the root element itself.
+func (sel scrapeSelector) value(root *goquery.Selection) string {
+	node := root
+	if sel.selector != "" {
+		node = root.Find(sel.selector)
+	}
+	node = node.First()
+	if node.Length() == 0 {
+		return ""
+	}
+
+	if sel.attr != "" {
+		val, _ := node.Attr(sel.attr)
+		return strings.TrimSpace(val)
+	}
+	return strings.Join(strings.Fields(node.Text()), " ")
+}
+
+func resolveSelectionUrls(selection *goquery.Selection, baseUrl *url.URL) {
+	for _, attr := range []string{"href", "src"} {
+		selection.Find("[" + attr + "]").Each(func(_ int, node *goquery.Selection) {
+			val, _ := node.Attr(attr)
+			node.SetAttr(attr, resolveUrl(baseUrl, val))
+		})
+	}
+}
+
+func (cfg scrapeConfig) itemFromSelection(node *goquery.Selection, pageUrl *url.URL) *gofeed.Item {
+	title := cfg.title.value(node)
+
+	link := ""
+	if cfg.link.selector != "" || cfg.link.attr != "" {
+		link = cfg.link.value(node)
+	}
+
+	dateStr := ""
+	if cfg.date.selector != "" || cfg.date.attr != "" {
+		dateStr = cfg.date.value(node)
+	}
+	published := parseDate(dateStr, cfg.dateFormat)
+	if dateStr != "" && published == nil {
+		util.LogWarn(fmt.Sprintf("%s: cannot parse date \"%s\"", pageUrl.String(), dateStr))
+	}
+
+	contentNode := node
+	if cfg.content != "" {
+		contentNode = node.Find(cfg.content).First()
+	}
+	resolveSelectionUrls(contentNode, pageUrl)
+	content, err := contentNode.Html()
+	if err != nil {
+		content = ""
+	}
+	content = strings.TrimSpace(content)
+
+	if title == "" && link == "" && content == "" {
+		return nil
+	}
+
+	guid := ""
+	if link == "" {
+		// the page itself is the only link, so make the items distinguishable
+		hash := sha1.Sum([]byte(title + "\n" + dateStr + "\n" + content))
+		link = pageUrl.String()
+		guid = link + "#" + hex.EncodeToString(hash[:])
+	} else {
+		link = resolveUrl(pageUrl, link)
+	}
+
+	return &gofeed.Item{
+		Title:           title,
+		Link:            link,
+		GUID:            guid,
+		Content:         content,
+		Published:       dateStr,
+		PublishedParsed: published,
+	}
+}
+
+func (cfg scrapeConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	pageUrl, err := url.Parse(realUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(realUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// respect <base href="...">
+	if baseHref, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		baseUrl, err := url.Parse(resolveUrl(pageUrl, baseHref))
+		if err == nil {
+			pageUrl = baseUrl
+		}
+	}
+
+	feed := &gofeed.Feed{
+		Title: strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+
+	doc.Find(cfg.item).Each(func(_ int, node *goquery.Selection) {
+		item := cfg.itemFromSelection(node, pageUrl)
+		if item != nil {
+			feed.Items = append(feed.Items, item)
+		}
+	})
+
+	if len(feed.Items) == 0 {
+		return nil, fmt.Errorf("no items found with selector \"%s\"", cfg.item)
+	}
+
+	return feed, nil
+}
+
+func NewScrapeSourceFuncs(options *viper.Viper) (*FeedTypeFuncs, error) {
+	cfg := scrapeConfig{
+		item:       strings.TrimSpace(options.GetString("item")),
+		title:      parseScrapeSelector(options.GetString("title"), ""),
+		link:       parseScrapeSelector(options.GetString("link"), "href"),
+		date:       parseScrapeSelector(options.GetString("date"), ""),
+		dateFormat: options.GetString("dateFormat"),
+		content:    strings.TrimSpace(options.GetString("content")),
+	}
+
+	if cfg.item == "" {
+		return nil, errors.New("the \"item\" selector is required for the scrape type")
+	}
+	if options.GetString("link") == "" {
+		cfg.link = scrapeSelector{}
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     HttpRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs, nil
+}
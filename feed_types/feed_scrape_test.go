@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import "testing"
+
+func TestParseScrapeSelector(t *testing.T) {
+	tests := []struct {
+		s        string
+		selector string
+		attr     string
+	}{
+		{"a.permalink@href", "a.permalink", "href"},
+		{" time @ datetime ", "time", "datetime"},
+		{"@data-id", "", "data-id"},
+		{"h2.title", "h2.title", "default"},
+		{`a[href^="mailto:"]`, `a[href^="mailto:"]`, "default"},
+		{`[data-x*="@"]`, `[data-x*="@"]`, "default"},
+		{`a[href*="@"]@href`, `a[href*="@"]`, "href"},
+	}
+
+	for _, test := range tests {
+		sel := parseScrapeSelector(test.s, "default")
+		if sel.selector != test.selector || sel.attr != test.attr {
+			t.Errorf("%q: got %q @ %q, expected %q @ %q", test.s, sel.selector, sel.attr, test.selector, test.attr)
+		}
+	}
+}
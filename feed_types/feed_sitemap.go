@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"feedmash/util"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Nested sitemap indexes deeper than this are ignored.
+const sitemapMaxDepth = 3
+
+// Covers both <urlset> and <sitemapindex>.
+type sitemapXml struct {
+	XMLName  xml.Name
+	Urls     []sitemapXmlEntry `xml:"url"`
+	Sitemaps []sitemapXmlEntry `xml:"sitemap"`
+}
+
+type sitemapXmlEntry struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod"`
+}
+
+// Saved in the state directory, so the already known pages are not reported again after a restart.
+type sitemapState struct {
+	Lastmods map[string]string `json:"lastmods"`
+}
+
+type sitemapConfig struct {
+	maxTitles int
+	maxItems  int
+}
+
+func IsSitemap(feedUrl url.URL) bool {
+	if !IsHttp(feedUrl) {
+		return false
+	}
+
+	name := strings.ToLower(path.Base(feedUrl.Path))
+	return strings.HasPrefix(name, "sitemap") && (strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".xml.gz"))
+}
+
+func loadSitemapXml(sitemapUrl string, userAgent string) (*sitemapXml, error) {
+	resp, err := httpGet(sitemapUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	bufReader := bufio.NewReader(resp.Body)
+	var reader io.Reader = bufReader
+	magic, err := bufReader.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	}
+
+	var sitemap sitemapXml
+	err = xml.NewDecoder(reader).Decode(&sitemap)
+	if err != nil {
+		return nil, err
+	}
+	return &sitemap, nil
+}
+
+// Returns the number of child sitemaps that failed to load.
+func collectSitemapUrls(sitemapUrl string, userAgent string, depth int, visited map[string]bool, lastmods map[string]string) (int, error) {
+	if visited[sitemapUrl] {
+		return 0, nil
+	}
+	visited[sitemapUrl] = true
+
+	sitemap, err := loadSitemapXml(sitemapUrl, userAgent)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range sitemap.Urls {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc != "" {
+			lastmods[loc] = strings.TrimSpace(entry.Lastmod)
+		}
+	}
+
+	if depth >= sitemapMaxDepth {
+		return 0, nil
+	}
+	nFailed := 0
+	for _, entry := range sitemap.Sitemaps {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+		nChildFailed, err := collectSitemapUrls(loc, userAgent, depth+1, visited, lastmods)
+		nFailed += nChildFailed
+		if err != nil {
+			// one broken child sitemap should not hide the others
+			util.LogWarn(err)
+			nFailed++
+		}
+	}
+
+	return nFailed, nil
+}
+
+func fetchPageTitle(pageUrl string, userAgent string) string {
+	resp, err := httpGet(pageUrl, userAgent)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	defer closeBody(resp.Body)
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	return strings.Join(strings.Fields(doc.Find("title").First().Text()), " ")
+}
+
+func (cfg sitemapConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	lastmods := map[string]string{}
+	nFailed, err := collectSitemapUrls(realUrl, userAgent, 0, map[string]bool{}, lastmods)
+	if err != nil {
+		return nil, err
+	}
+
+	stateKey := util.StateKey("sitemap", realUrl)
+	state := sitemapState{}
+	hasState := util.LoadState(stateKey, &state)
+
+	feed := &gofeed.Feed{
+		Title: realUrl,
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+
+	// On the first run just remember all the pages.
+	if hasState {
+		locs := make([]string, 0, len(lastmods))
+		for loc := range lastmods {
+			locs = append(locs, loc)
+		}
+		sort.Strings(locs)
+
+		nTitles := 0
+		nPostponed := 0
+		for _, loc := range locs {
+			lastmod := lastmods[loc]
+			oldLastmod, isKnown := state.Lastmods[loc]
+			if isKnown && oldLastmod == lastmod {
+				continue
+			}
+
+			// the rest of the changed pages are reported next time
+			if cfg.maxItems > 0 && len(feed.Items) >= cfg.maxItems {
+				if isKnown {
+					lastmods[loc] = oldLastmod
+				} else {
+					delete(lastmods, loc)
+				}
+				nPostponed++
+				continue
+			}
+
+			// titles are fetched only for new pages, and only for a limited number of them
+			title := ""
+			if nTitles < cfg.maxTitles {
+				title = fetchPageTitle(loc, userAgent)
+				nTitles++
+			}
+			if title == "" {
+				title = loc
+			}
+
+			item := &gofeed.Item{
+				Title: title,
+				Link:  loc,
+				GUID:  loc,
+			}
+			if lastmod != "" {
+				item.GUID = loc + "#" + lastmod
+				item.PublishedParsed = parseDate(lastmod, "")
+			}
+			if item.PublishedParsed == nil {
+				now := time.Now()
+				item.PublishedParsed = &now
+			}
+			feed.Items = append(feed.Items, item)
+		}
+
+		if nPostponed > 0 {
+			util.LogInfo(fmt.Sprintf("%s: %d changed page(s) postponed until the next update", realUrl, nPostponed))
+		}
+	} else {
+		// An incomplete first list is not saved, otherwise all the missing pages would be reported as new next time.
+		if nFailed > 0 || len(lastmods) == 0 {
+			util.LogWarn(fmt.Sprintf("%s: found %d pages, %d sitemaps failed to load; will try again next time", realUrl, len(lastmods), nFailed))
+			return feed, nil
+		}
+		util.LogInfo(fmt.Sprintf("%s: remembered %d pages", realUrl, len(lastmods)))
+	}
+
+	// Keep the pages that are missing now, e.g. because some child sitemap failed to load,
+	// so they won't be reported as new when they're back.
+	if state.Lastmods == nil {
+		state.Lastmods = map[string]string{}
+	}
+	for loc, lastmod := range lastmods {
+		state.Lastmods[loc] = lastmod
+	}
+	saveStateWithFeed(feed, stateKey, state)
+
+	return feed, nil
+}
+
+func NewSitemapSourceFuncs(options *viper.Viper) *FeedTypeFuncs {
+	options.SetDefault("maxTitles", 20)
+	options.SetDefault("maxItems", 100)
+	cfg := sitemapConfig{
+		maxTitles: options.GetInt("maxTitles"),
+		maxItems:  options.GetInt("maxItems"),
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     HttpRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSitemapState(t *testing.T) {
+	setTestStateDir(t)
+
+	nPages := 3
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var urls []string
+		for i := 1; i <= nPages; i++ {
+			urls = append(urls, fmt.Sprintf("<url><loc>http://%s/page%d</loc></url>", r.Host, i))
+		}
+		_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">%s</urlset>`, strings.Join(urls, ""))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := sitemapConfig{maxItems: 2}
+	load := func() (int, func()) {
+		feed, err := cfg.loadFeed(server.URL+"/sitemap.xml", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(feed.Items), TakeFeedState(feed)
+	}
+
+	nItems, commit := load()
+	if nItems != 0 {
+		t.Fatalf("the first download must only remember the pages, got %d items", nItems)
+	}
+	commit()
+
+	nPages = 6
+	nItems, _ = load()
+	if nItems != 2 {
+		t.Fatalf("expected 2 items (maxItems), got %d", nItems)
+	}
+
+	// the state is not saved without the commit, so the same pages are reported again
+	nItems, commit = load()
+	if nItems != 2 {
+		t.Fatalf("expected the same 2 items again, got %d", nItems)
+	}
+	commit()
+
+	nItems, commit = load()
+	if nItems != 1 {
+		t.Fatalf("expected the postponed item, got %d", nItems)
+	}
+	commit()
+
+	nItems, _ = load()
+	if nItems != 0 {
+		t.Fatalf("expected no items, got %d", nItems)
+	}
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"feedmash/util"
+	"github.com/mmcdole/gofeed"
+	"sync"
+)
+
+type pendingState struct {
+	name  string
+	value interface{}
+}
+
+// The states that describe what the stateful types have already reported.
+// They are saved only after the feed is written to the output feed (see TakeFeedState),
+// otherwise the items would be lost if the write fails.
+var (
+	pendingStates      = map[*gofeed.Feed][]pendingState{}
+	pendingStatesMutex sync.Mutex
+)
+
+// Saves the state together with the feed.
+func saveStateWithFeed(feed *gofeed.Feed, name string, v interface{}) {
+	pendingStatesMutex.Lock()
+	defer pendingStatesMutex.Unlock()
+	pendingStates[feed] = append(pendingStates[feed], pendingState{name: name, value: v})
+}
+
+// Returns the function that saves the states of the loaded feed.
+// Call it after the items of the feed are written; just drop it to discard the states.
+func TakeFeedState(feed *gofeed.Feed) func() {
+	pendingStatesMutex.Lock()
+	states := pendingStates[feed]
+	delete(pendingStates, feed)
+	pendingStatesMutex.Unlock()
+
+	return func() {
+		for _, state := range states {
+			util.SaveState(state.name, state.value)
+		}
+	}
+}
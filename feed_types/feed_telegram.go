@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"feedmash/util"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const telegramMaxTitleLen = 100
+
+var telegramChannelRx = regexp.MustCompile(`^/(?:s/)?([A-Za-z0-9_]{4,})/?$`)
+var telegramBgImageRx = regexp.MustCompile(`background-image:\s*url\(['"]?([^'")]+)['"]?\)`)
+
+// The newest message that was already seen, so the older pages are not downloaded again.
+type telegramState struct {
+	LastId int `json:"lastId"`
+}
+
+type telegramConfig struct {
+	maxPages int
+}
+
+func telegramChannel(feedUrl url.URL) string {
+	host := strings.ToLower(feedUrl.Host)
+	if host != "t.me" && host != "telegram.me" && host != "www.t.me" && host != "www.telegram.me" {
+		return ""
+	}
+	matches := telegramChannelRx.FindStringSubmatch(feedUrl.Path)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+func IsTelegram(feedUrl url.URL) bool {
+	return strings.ToLower(feedUrl.Scheme) == "https" && telegramChannel(feedUrl) != ""
+}
+
+// Public channels have a web preview at https://t.me/s/<channel>.
+func TelegramRealUrl(feedUrl url.URL) string {
+	channel := telegramChannel(feedUrl)
+	if channel == "" {
+		util.LogWarn("Not a Telegram channel URL: " + feedUrl.String())
+		return ""
+	}
+	return "https://t.me/s/" + channel
+}
+
+func telegramBgImage(selection *goquery.Selection) string {
+	style, _ := selection.Attr("style")
+	matches := telegramBgImageRx.FindStringSubmatch(style)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+func telegramTitle(text string) string {
+	text = strings.TrimSpace(text)
+	firstLine, _, _ := strings.Cut(text, "\n")
+	firstLine = strings.Join(strings.Fields(firstLine), " ")
+	runes := []rune(firstLine)
+	if len(runes) > telegramMaxTitleLen {
+		return string(runes[:telegramMaxTitleLen]) + "…"
+	}
+	return firstLine
+}
+
+func telegramMessageToItem(msg *goquery.Selection, pageUrl *url.URL) (*gofeed.Item, int) {
+	post, _ := msg.Attr("data-post") // "<channel>/<id>"
+	_, idStr, _ := strings.Cut(post, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, 0
+	}
+	link := "https://t.me/" + post
+
+	var sb strings.Builder
+
+	forwarded := msg.Find(".tgme_widget_message_forwarded_from").First()
+	if forwarded.Length() > 0 {
+		fromName := strings.TrimSpace(forwarded.Find(".tgme_widget_message_forwarded_from_name").Text())
+		fromLink, _ := forwarded.Find("a.tgme_widget_message_forwarded_from_name").Attr("href")
+		if fromName == "" {
+			fromName = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(forwarded.Text()), "Forwarded from"))
+		}
+		if fromLink != "" {
+			sb.WriteString(fmt.Sprintf(`<p><i>Forwarded from <a href="%s">%s</a></i></p>`, html.EscapeString(resolveUrl(pageUrl, fromLink)), html.EscapeString(fromName)))
+		} else {
+			sb.WriteString(fmt.Sprintf("<p><i>Forwarded from %s</i></p>", html.EscapeString(fromName)))
+		}
+	}
+
+	msg.Find("a.tgme_widget_message_photo_wrap").Each(func(_ int, photo *goquery.Selection) {
+		imgUrl := telegramBgImage(photo)
+		if imgUrl != "" {
+			sb.WriteString(fmt.Sprintf(`<p><a href="%s"><img src="%s"/></a></p>`, html.EscapeString(link), html.EscapeString(imgUrl)))
+		}
+	})
+
+	msg.Find(".tgme_widget_message_video_thumb, .link_preview_image").Each(func(_ int, thumb *goquery.Selection) {
+		imgUrl := telegramBgImage(thumb)
+		if imgUrl != "" {
+			sb.WriteString(fmt.Sprintf(`<p><a href="%s"><img src="%s"/></a></p>`, html.EscapeString(link), html.EscapeString(imgUrl)))
+		}
+	})
+
+	textNode := msg.Find(".tgme_widget_message_text").First()
+	text := ""
+	if textNode.Length() > 0 {
+		textNode.Find("br").ReplaceWithHtml("\n")
+		text = textNode.Text()
+		resolveSelectionUrls(textNode, pageUrl)
+		textHtml, err := textNode.Html()
+		if err == nil {
+			sb.WriteString("<p>" + strings.ReplaceAll(strings.TrimSpace(textHtml), "\n", "<br/>") + "</p>")
+		}
+	}
+
+	title := telegramTitle(text)
+	if title == "" {
+		switch {
+		case msg.Find(".tgme_widget_message_video_thumb").Length() > 0:
+			title = "Video"
+		case msg.Find(".tgme_widget_message_photo_wrap").Length() > 0:
+			title = "Photo"
+		default:
+			title = "Message " + idStr
+		}
+	}
+
+	item := &gofeed.Item{
+		Title:   title,
+		Link:    link,
+		GUID:    link,
+		Content: sb.String(),
+	}
+
+	dateStr, _ := msg.Find(".tgme_widget_message_date time").First().Attr("datetime")
+	if dateStr != "" {
+		date, err := time.Parse(time.RFC3339, dateStr)
+		if err == nil {
+			item.PublishedParsed = &date
+		}
+	}
+
+	author := strings.TrimSpace(msg.Find(".tgme_widget_message_owner_name").First().Text())
+	if author != "" {
+		item.Authors = []*gofeed.Person{{Name: author}}
+	}
+
+	return item, id
+}
+
+func (cfg telegramConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	pageUrl, err := url.Parse(realUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	stateKey := util.StateKey("telegram", realUrl)
+	state := telegramState{}
+	hasState := util.LoadState(stateKey, &state)
+
+	feed := &gofeed.Feed{
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+	newLastId := state.LastId
+
+	// go back page by page until the last seen message
+	nextUrl := realUrl
+	isComplete := false
+	isFailed := false
+	for page := 0; page < cfg.maxPages; page++ {
+		resp, err := httpGet(nextUrl, userAgent)
+		if err != nil {
+			if page == 0 {
+				return nil, err
+			}
+			util.LogWarn(err)
+			isFailed = true
+			break
+		}
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		closeBody(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if feed.Title == "" {
+			feed.Title = strings.TrimSpace(doc.Find(".tgme_channel_info_header_title").First().Text())
+		}
+
+		minId := 0
+		doc.Find(".tgme_widget_message[data-post]").Each(func(_ int, msg *goquery.Selection) {
+			item, id := telegramMessageToItem(msg, pageUrl)
+			if item == nil {
+				return
+			}
+			if minId == 0 || id < minId {
+				minId = id
+			}
+			if id <= state.LastId {
+				return
+			}
+			newLastId = max(newLastId, id)
+			feed.Items = append(feed.Items, item)
+		})
+
+		// on the first run just take the latest page
+		if !hasState || minId <= state.LastId+1 || minId <= 1 {
+			isComplete = true
+			break
+		}
+		nextUrl = realUrl + "?before=" + strconv.Itoa(minId)
+	}
+
+	if feed.Title == "" {
+		feed.Title = realUrl
+	}
+
+	// The messages that are not read are fetched next time,
+	// unless there are more of them than maxPages allows.
+	if isFailed {
+		return feed, nil
+	}
+	if !isComplete {
+		util.LogWarn(fmt.Sprintf("%s: more than %d page(s) of new messages, the older ones are skipped", realUrl, cfg.maxPages))
+	}
+
+	if newLastId != state.LastId {
+		state.LastId = newLastId
+		saveStateWithFeed(feed, stateKey, state)
+	}
+
+	return feed, nil
+}
+
+func NewTelegramSourceFuncs(options *viper.Viper) *FeedTypeFuncs {
+	options.SetDefault("maxPages", 5)
+	cfg := telegramConfig{
+		maxPages: options.GetInt("maxPages"),
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     TelegramRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs
+}
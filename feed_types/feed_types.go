@@ -4,29 +4,147 @@
 package feed_types
 
 import (
+	"feedmash/util"
 	"fmt"
 	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
 	"net/url"
 	"os"
+	"strings"
 )
 
 const (
 	Unknown = iota
 	Http
 	Youtube
+	Scrape
+	Json
+	Sitemap
+	Watch
+	Gemini
+	Email
+	Ics
+	Telegram
 )
 
+var typeNames = []string{"unknown", "http", "youtube", "scrape", "json", "sitemap", "watch", "gemini", "email", "ics", "telegram"}
+
+func TypeName(feedType int) string {
+	if feedType < 0 || feedType >= len(typeNames) {
+		return typeNames[Unknown]
+	}
+	return typeNames[feedType]
+}
+
+// These types only report what has changed since the previous download (or what's upcoming),
+// so a feed without items or with old items is normal for them.
+func IsStateful(feedType int) bool {
+	switch feedType {
+	case Sitemap, Watch, Ics, Telegram:
+		return true
+	default:
+		return false
+	}
+}
+
 type FeedTypeFuncs struct {
 	RealUrl                     func(feedUrl url.URL) string
+	LoadFeed                    func(realUrl string, userAgent string) (*gofeed.Feed, error)
 	SourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item
 }
 
-func Detect(feedUrl url.URL) (int, *FeedTypeFuncs) {
+// options contains the source entry from the config file.
+// The type is detected by the URL unless the "type" option is set explicitly.
+// User-defined rewrite rules are checked before the built-in types.
+func Detect(feedUrl url.URL, options *viper.Viper) (int, *FeedTypeFuncs) {
+	feedType, funcs := DetectWithoutFulltext(feedUrl, options)
+
+	if funcs != nil && options.GetBool("fulltext") {
+		fulltextFuncs := *funcs
+		fulltextFuncs.LoadFeed = withFulltext(funcs.LoadFeed)
+		funcs = &fulltextFuncs
+	}
+
+	return feedType, funcs
+}
+
+// Same as Detect, but the "fulltext" option is ignored, so the articles are not downloaded.
+func DetectWithoutFulltext(feedUrl url.URL, options *viper.Viper) (int, *FeedTypeFuncs) {
+	var feedType int
+	var funcs *FeedTypeFuncs
+	rule, rewrittenUrl := FindRewriteRule(feedUrl)
+	if rule == nil {
+		feedType, funcs = detectBuiltin(feedUrl, options)
+	} else {
+		feedType, funcs = detectBuiltin(*rewrittenUrl, options)
+		if funcs != nil {
+			funcs = rule.wrapFuncs(funcs, *rewrittenUrl)
+		}
+	}
+
+	return feedType, funcs
+}
+
+func detectBuiltin(feedUrl url.URL, options *viper.Viper) (int, *FeedTypeFuncs) {
+	typeName := strings.ToLower(options.GetString("type"))
+	switch typeName {
+	case "":
+		break
+
+	case "scrape":
+		funcs, err := NewScrapeSourceFuncs(options)
+		if err != nil {
+			util.LogWarn(fmt.Sprintf("%s: %s", feedUrl.String(), err))
+			return Unknown, nil
+		}
+		return Scrape, funcs
+
+	case "json":
+		funcs, err := NewJsonSourceFuncs(options)
+		if err != nil {
+			util.LogWarn(fmt.Sprintf("%s: %s", feedUrl.String(), err))
+			return Unknown, nil
+		}
+		return Json, funcs
+
+	case "sitemap":
+		return Sitemap, NewSitemapSourceFuncs(options)
+
+	case "watch":
+		return Watch, NewWatchSourceFuncs(options)
+
+	case "ics":
+		return Ics, NewIcsSourceFuncs(options)
+
+	case "telegram":
+		return Telegram, NewTelegramSourceFuncs(options)
+
+	default:
+		util.LogWarn(fmt.Sprintf("Unknown feed type \"%s\": %s", typeName, feedUrl.String()))
+		return Unknown, nil
+	}
+
 	if IsYoutube(feedUrl) {
 		return Youtube, &youtubeSourceFuncs
 	}
 
+	if IsTelegram(feedUrl) {
+		return Telegram, NewTelegramSourceFuncs(options)
+	}
+
+	if IsGemini(feedUrl) {
+		return Gemini, NewGeminiSourceFuncs(options)
+	}
+
+	if IsIcs(feedUrl) {
+		return Ics, NewIcsSourceFuncs(options)
+	}
+
+	if IsSitemap(feedUrl) {
+		return Sitemap, NewSitemapSourceFuncs(options)
+	}
+
 	if IsHttp(feedUrl) {
 		return Http, &httpSourceFuncs
 	}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"feedmash/util"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+	"strings"
+	"time"
+)
+
+// Number of unchanged lines to show around the changes.
+const watchDiffContext = 2
+
+// Bigger pages are not diffed line by line, the whole old and new text is shown instead.
+const watchMaxDiffLines = 2000
+
+var watchBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+var watchSkippedTags = map[string]bool{
+	"head": true, "noscript": true, "script": true, "style": true, "template": true,
+}
+
+// The last seen version of the page.
+type watchState struct {
+	Text string `json:"text"`
+}
+
+type watchConfig struct {
+	selector string
+}
+
+func collectWatchText(node *html.Node, sb *strings.Builder) {
+	switch node.Type {
+	case html.TextNode:
+		sb.WriteString(node.Data)
+		return
+
+	case html.ElementNode:
+		if watchSkippedTags[node.Data] {
+			return
+		}
+	}
+
+	isBlock := node.Type == html.ElementNode && watchBlockTags[node.Data]
+	if isBlock {
+		sb.WriteString("\n")
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		collectWatchText(child, sb)
+	}
+	if isBlock {
+		sb.WriteString("\n")
+	}
+}
+
+// Returns the visible text, one block per line, with whitespace collapsed and empty lines removed.
+func normalizeWatchText(selection *goquery.Selection) string {
+	var sb strings.Builder
+	for _, node := range selection.Nodes {
+		collectWatchText(node, &sb)
+		sb.WriteString("\n")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// A plain LCS diff, good enough for the text of a single page.
+func diffLines(oldLines []string, newLines []string) []diffOp {
+	n := len(oldLines)
+	m := len(newLines)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case oldLines[i] == newLines[j]:
+			ops = append(ops, diffOp{' ', oldLines[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', oldLines[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', newLines[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', oldLines[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', newLines[j]})
+	}
+	return ops
+}
+
+func diffOpsToHtml(ops []diffOp) string {
+	show := make([]bool, len(ops))
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		for k := max(0, i-watchDiffContext); k <= min(len(ops)-1, i+watchDiffContext); k++ {
+			show[k] = true
+		}
+	}
+
+	var sb strings.Builder
+	isSkipping := false
+	for i, op := range ops {
+		if !show[i] {
+			if !isSkipping {
+				sb.WriteString("<p>…</p>")
+				isSkipping = true
+			}
+			continue
+		}
+		isSkipping = false
+
+		line := html.EscapeString(op.line)
+		switch op.kind {
+		case '-':
+			sb.WriteString("<p><del>" + line + "</del></p>")
+		case '+':
+			sb.WriteString("<p><ins>" + line + "</ins></p>")
+		default:
+			sb.WriteString("<p>" + line + "</p>")
+		}
+	}
+	return sb.String()
+}
+
+func watchDiffHtml(oldText string, newText string) string {
+	oldLines := strings.Split(oldText, "\n")
+	newLines := strings.Split(newText, "\n")
+
+	if len(oldLines) > watchMaxDiffLines || len(newLines) > watchMaxDiffLines {
+		var ops []diffOp
+		for _, line := range oldLines {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range newLines {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return diffOpsToHtml(ops)
+	}
+
+	return diffOpsToHtml(diffLines(oldLines, newLines))
+}
+
+func (cfg watchConfig) loadFeed(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	resp, err := httpGet(realUrl, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pageTitle := strings.Join(strings.Fields(doc.Find("title").First().Text()), " ")
+	if pageTitle == "" {
+		pageTitle = realUrl
+	}
+
+	selection := doc.Selection
+	if cfg.selector != "" {
+		selection = doc.Find(cfg.selector)
+		if selection.Length() == 0 {
+			return nil, fmt.Errorf("nothing matches the selector \"%s\"", cfg.selector)
+		}
+	}
+	newText := normalizeWatchText(selection)
+
+	feed := &gofeed.Feed{
+		Title: pageTitle,
+		Link:  realUrl,
+		Items: []*gofeed.Item{},
+	}
+
+	stateKey := util.StateKey("watch", realUrl+"\n"+cfg.selector)
+	state := watchState{}
+	hasState := util.LoadState(stateKey, &state)
+	if hasState && state.Text == newText {
+		return feed, nil
+	}
+
+	// On the first run just remember the page.
+	if hasState {
+		// the old text is hashed as well in case the page is reverted to some previous version
+		hash := sha1.Sum([]byte(state.Text + "\x00" + newText))
+		now := time.Now()
+		feed.Items = append(feed.Items, &gofeed.Item{
+			Title:           pageTitle + ": changed",
+			Link:            realUrl,
+			GUID:            realUrl + "#" + hex.EncodeToString(hash[:]),
+			Content:         watchDiffHtml(state.Text, newText),
+			PublishedParsed: &now,
+		})
+	}
+
+	state.Text = newText
+	saveStateWithFeed(feed, stateKey, state)
+
+	return feed, nil
+}
+
+func NewWatchSourceFuncs(options *viper.Viper) *FeedTypeFuncs {
+	cfg := watchConfig{
+		selector: strings.TrimSpace(options.GetString("selector")),
+	}
+
+	funcs := FeedTypeFuncs{
+		RealUrl:                     HttpRealUrl,
+		LoadFeed:                    cfg.loadFeed,
+		SourceFeedItemToOutFeedItem: HttpSourceFeedItemToOutFeedItem,
+	}
+	return &funcs
+}
@@ -94,12 +94,17 @@ func YoutubeSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 	thumbnailUrl := item.Extensions["media"]["group"][0].Children["thumbnail"][0].Attrs["url"]
 	content := item.Extensions["media"]["group"][0].Children["description"][0].Value
 
+	href := item.Link
+	if outItem.Link != nil && outItem.Link.Href != "" {
+		href = outItem.Link.Href
+	}
+
 	data := struct {
 		Href         string
 		ThumbnailUrl string
 		Content      string
 	}{
-		Href:         outItem.Link.Href,
+		Href:         href,
 		ThumbnailUrl: thumbnailUrl,
 		Content:      content,
 	}
@@ -119,5 +124,6 @@ func YoutubeSourceFeedItemToOutFeedItem(item *gofeed.Item) *feeds.Item {
 
 var youtubeSourceFuncs = FeedTypeFuncs{
 	RealUrl:                     YoutubeRealUrl,
+	LoadFeed:                    HttpLoadFeed,
 	SourceFeedItemToOutFeedItem: YoutubeSourceFeedItemToOutFeedItem,
 }
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"feedmash/util"
+	"github.com/mmcdole/gofeed"
+	"time"
+)
+
+// When the undated items were seen for the first time, by their GUID.
+type firstSeenState struct {
+	Times map[string]time.Time `json:"times"`
+}
+
+// Gives a date to the items that don't have one: the updated date, or the time when the item was first seen.
+// The first seen time is kept in the state, so the item doesn't jump to the top of the feed after a restart.
+func FillMissingDates(feed *gofeed.Feed, realUrl string) {
+	stateKey := util.StateKey("firstseen", realUrl)
+	state := firstSeenState{}
+	util.LoadState(stateKey, &state)
+
+	// only keep the items that are still in the feed
+	newState := firstSeenState{Times: map[string]time.Time{}}
+	isChanged := false
+	// the output feed has the dates with seconds precision
+	now := time.Now().Truncate(time.Second)
+
+	for _, item := range feed.Items {
+		if item.PublishedParsed != nil {
+			continue
+		}
+
+		// the ID of an undated item doesn't depend on the date (see HttpSourceFeedItemToOutFeedItem)
+		if item.GUID == "" {
+			item.GUID = item.Link
+			if item.GUID == "" {
+				item.GUID = contentHashGuid(item)
+			}
+		}
+
+		if item.UpdatedParsed != nil {
+			item.PublishedParsed = item.UpdatedParsed
+			continue
+		}
+
+		firstSeen, isKnown := state.Times[item.GUID]
+		if !isKnown {
+			firstSeen = now
+			isChanged = true
+		}
+		newState.Times[item.GUID] = firstSeen
+		item.PublishedParsed = &firstSeen
+	}
+
+	if isChanged || len(newState.Times) != len(state.Times) {
+		util.SaveState(stateKey, newState)
+	}
+}
@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const fulltextMinParagraphLen = 25
+const fulltextMinTextLen = 250
+
+// The pages that failed to load are retried after this delay, doubled after each failure.
+const fulltextRetryMinDelay = 6 * time.Hour
+const fulltextRetryMaxDelay = 7 * 24 * time.Hour
+
+var fulltextNoiseSelector = "script, style, noscript, template, nav, header, footer, aside, form, button, svg, iframe"
+var fulltextNegativeRx = regexp.MustCompile(`(?i)comment|sidebar|footer|foot|nav|menu|share|social|related|sponsor|advert|\bads?\b|promo|banner|cookie|popup|modal|subscribe|newsletter|breadcrumb|masthead|widget`)
+var fulltextPositiveRx = regexp.MustCompile(`(?i)article|body|content|entry|main|post|text|blog|story`)
+
+type fulltextFailure struct {
+	Count      int       `json:"count"`
+	RetryAfter time.Time `json:"retryAfter"`
+}
+
+// The extracted content of the already processed links, and the links that failed to load.
+type fulltextState struct {
+	Contents map[string]string          `json:"contents"`
+	Failures map[string]fulltextFailure `json:"failures,omitempty"`
+}
+
+func nextFulltextFailure(failure fulltextFailure) fulltextFailure {
+	delay := fulltextRetryMaxDelay
+	if failure.Count < 10 {
+		delay = min(fulltextRetryMinDelay<<failure.Count, fulltextRetryMaxDelay)
+	}
+	return fulltextFailure{
+		Count:      failure.Count + 1,
+		RetryAfter: time.Now().Add(delay),
+	}
+}
+
+func classWeight(node *goquery.Selection) float64 {
+	class, _ := node.Attr("class")
+	id, _ := node.Attr("id")
+	weight := 0.0
+	for _, s := range []string{class, id} {
+		if s == "" {
+			continue
+		}
+		if fulltextNegativeRx.MatchString(s) {
+			weight -= 25
+		}
+		if fulltextPositiveRx.MatchString(s) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+func linkDensity(node *goquery.Selection) float64 {
+	textLen := len(strings.TrimSpace(node.Text()))
+	if textLen == 0 {
+		return 0
+	}
+	linkLen := 0
+	node.Find("a").Each(func(_ int, link *goquery.Selection) {
+		linkLen += len(strings.TrimSpace(link.Text()))
+	})
+	return float64(linkLen) / float64(textLen)
+}
+
+// Finds the element with the main content of the page,
+// scoring the parents of the paragraphs similar to Readability.
+func extractMainContent(doc *goquery.Document) *goquery.Selection {
+	body := doc.Find("body")
+	body.Find(fulltextNoiseSelector).Remove()
+	body.Find("*").Each(func(_ int, node *goquery.Selection) {
+		if node.Is("body, article, main") {
+			return
+		}
+		class, _ := node.Attr("class")
+		id, _ := node.Attr("id")
+		if fulltextNegativeRx.MatchString(class+" "+id) && !fulltextPositiveRx.MatchString(class+" "+id) {
+			node.Remove()
+		}
+	})
+
+	scores := map[*html.Node]float64{}
+	var candidates []*goquery.Selection
+	addScore := func(node *goquery.Selection, score float64) {
+		if node.Length() == 0 || node.Is("body, html") {
+			return
+		}
+		key := node.Get(0)
+		if _, isKnown := scores[key]; !isKnown {
+			scores[key] = classWeight(node)
+			if node.Is("article, main") {
+				scores[key] += 25
+			}
+			candidates = append(candidates, node)
+		}
+		scores[key] += score
+	}
+
+	body.Find("p, pre, td, blockquote").Each(func(_ int, paragraph *goquery.Selection) {
+		text := strings.TrimSpace(paragraph.Text())
+		if len(text) < fulltextMinParagraphLen {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+		parent := paragraph.Parent()
+		addScore(parent, score)
+		addScore(parent.Parent(), score/2)
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, candidate := range candidates {
+		score := scores[candidate.Get(0)] * (1 - linkDensity(candidate))
+		if best == nil || score > bestScore {
+			best = candidate
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		article := body.Find("article").First()
+		if article.Length() > 0 {
+			return article
+		}
+		return nil
+	}
+	return best
+}
+
+func fetchFulltext(link string, userAgent string) (string, error) {
+	pageUrl, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if !IsHttp(*pageUrl) {
+		return "", errors.New(link + ": not an HTTP link")
+	}
+
+	resp, err := httpGet(link, userAgent)
+	if err != nil {
+		return "", err
+	}
+	defer closeBody(resp.Body)
+
+	reader, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return "", err
+	}
+
+	// the page may be redirected
+	pageUrl = resp.Request.URL
+	if baseHref, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		baseUrl, err := url.Parse(resolveUrl(pageUrl, baseHref))
+		if err == nil {
+			pageUrl = baseUrl
+		}
+	}
+
+	content := extractMainContent(doc)
+	if content == nil || len(strings.TrimSpace(content.Text())) < fulltextMinTextLen {
+		return "", errors.New(link + ": the main content is not found")
+	}
+
+	resolveSelectionUrls(content, pageUrl)
+	return goquery.OuterHtml(content)
+}
+
+// Replaces the content of each item with the article from the item's link.
+// The original content is kept as the description.
+func withFulltext(loadFeed func(realUrl string, userAgent string) (*gofeed.Feed, error)) func(realUrl string, userAgent string) (*gofeed.Feed, error) {
+	return func(realUrl string, userAgent string) (*gofeed.Feed, error) {
+		feed, err := loadFeed(realUrl, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		// the item links must be absolute to download them
+		ResolveFeedUrls(feed, realUrl)
+
+		stateKey := util.StateKey("fulltext", realUrl)
+		state := fulltextState{}
+		util.LoadState(stateKey, &state)
+
+		// only keep the links that are still in the feed
+		newState := fulltextState{Contents: map[string]string{}, Failures: map[string]fulltextFailure{}}
+		nFetched := 0
+		nFailed := 0
+
+		for _, item := range feed.Items {
+			if item.Link == "" {
+				continue
+			}
+
+			content, isCached := state.Contents[item.Link]
+			if !isCached {
+				failure, hasFailed := state.Failures[item.Link]
+				if hasFailed && time.Now().Before(failure.RetryAfter) {
+					newState.Failures[item.Link] = failure
+					continue
+				}
+
+				content, err = fetchFulltext(item.Link, userAgent)
+				if err != nil {
+					util.LogWarn(err)
+					newState.Failures[item.Link] = nextFulltextFailure(failure)
+					nFailed++
+					continue
+				}
+				nFetched++
+			}
+			newState.Contents[item.Link] = content
+
+			summary := item.Content
+			if summary == "" {
+				summary = item.Description
+			}
+			item.Description = summary
+			item.Content = content
+		}
+
+		if nFetched > 0 || nFailed > 0 || len(newState.Contents) != len(state.Contents) || len(newState.Failures) != len(state.Failures) {
+			util.SaveState(stateKey, newState)
+		}
+		if nFetched > 0 {
+			util.LogInfo(fmt.Sprintf("%s: full text extracted for %d item(s)", realUrl, nFetched))
+		}
+
+		return feed, nil
+	}
+}
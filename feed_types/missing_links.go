@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/mmcdole/gofeed"
+	"net/url"
+	"strings"
+)
+
+func isHttpUrl(s string) bool {
+	urlObj, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && urlObj.Host != "" && IsHttp(*urlObj)
+}
+
+// The ID for the items that have neither GUID nor link, e.g. microblog statuses.
+func contentHashGuid(item *gofeed.Item) string {
+	hash := sha1.Sum([]byte(item.Title + "\n" + item.Description + "\n" + item.Content))
+	return "sha1:" + hex.EncodeToString(hash[:])
+}
+
+// Gives a link to the items that don't have one:
+// the enclosure URL (e.g. podcast episodes), the GUID if it's a URL, or the site link.
+// The items that have neither GUID nor their own link get the GUID from the hash of their content,
+// because the site link is the same for all of them.
+// Returns the number of items that got the site link or no link at all.
+func FillMissingLinks(feed *gofeed.Feed) int {
+	nWithoutLinks := 0
+	for _, item := range feed.Items {
+		if item.Link != "" {
+			continue
+		}
+
+		for _, enclosure := range item.Enclosures {
+			if enclosure.URL != "" {
+				item.Link = enclosure.URL
+				break
+			}
+		}
+		if item.Link == "" && isHttpUrl(item.GUID) {
+			item.Link = strings.TrimSpace(item.GUID)
+		}
+		if item.Link != "" {
+			continue
+		}
+
+		if item.GUID == "" {
+			item.GUID = contentHashGuid(item)
+		}
+		item.Link = feed.Link
+		nWithoutLinks++
+	}
+	return nWithoutLinks
+}
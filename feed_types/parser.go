@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"time"
+)
+
+// The keys of the gofeed Custom maps for the Atom data that gofeed doesn't keep.
+const feedIdCustomKey = "id"
+const sourceTitleCustomKey = "source:title"
+const sourceIdCustomKey = "source:id"
+const sourceLinkCustomKey = "source:link"
+const sourceFeedLinkCustomKey = "source:feedLink"
+const sourceUpdatedCustomKey = "source:updated"
+
+// The feed where the item was originally published.
+type ItemOrigin struct {
+	Title    string
+	Id       string
+	Link     string
+	FeedLink string
+	Updated  *time.Time
+}
+
+// gofeed doesn't keep the contributors and the <source> of Atom entries, and the ID of Atom feeds.
+// The contributors are put to the item extensions, like <atom:contributor> in RSS,
+// and the rest is put to the Custom maps.
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+func setCustom(custom map[string]string, key string, value string) map[string]string {
+	if value == "" {
+		return custom
+	}
+	if custom == nil {
+		custom = map[string]string{}
+	}
+	custom[key] = value
+	return custom
+}
+
+func atomSourceToCustom(source *atom.Source, custom map[string]string) map[string]string {
+	custom = setCustom(custom, sourceTitleCustomKey, source.Title)
+	custom = setCustom(custom, sourceIdCustomKey, source.ID)
+	for _, link := range source.Links {
+		switch link.Rel {
+		case "", "alternate":
+			custom = setCustom(custom, sourceLinkCustomKey, link.Href)
+		case "self":
+			custom = setCustom(custom, sourceFeedLinkCustomKey, link.Href)
+		}
+	}
+	if source.UpdatedParsed != nil {
+		custom = setCustom(custom, sourceUpdatedCustomKey, source.UpdatedParsed.Format(time.RFC3339))
+	}
+	return custom
+}
+
+func personExtension(name string, person *atom.Person) ext.Extension {
+	children := map[string][]ext.Extension{}
+	if person.Name != "" {
+		children["name"] = []ext.Extension{{Name: "name", Value: person.Name}}
+	}
+	if person.Email != "" {
+		children["email"] = []ext.Extension{{Name: "email", Value: person.Email}}
+	}
+	return ext.Extension{Name: name, Children: children}
+}
+
+func (translator *atomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := translator.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	atomFeed := feed.(*atom.Feed)
+	result.Custom = setCustom(result.Custom, feedIdCustomKey, atomFeed.ID)
+	for i, entry := range atomFeed.Entries {
+		if i >= len(result.Items) {
+			continue
+		}
+		item := result.Items[i]
+		if entry.Source != nil {
+			item.Custom = atomSourceToCustom(entry.Source, item.Custom)
+		}
+		if len(entry.Contributors) == 0 {
+			continue
+		}
+		if item.Extensions == nil {
+			item.Extensions = ext.Extensions{}
+		}
+		if item.Extensions["atom"] == nil {
+			item.Extensions["atom"] = map[string][]ext.Extension{}
+		}
+		for _, contributor := range entry.Contributors {
+			item.Extensions["atom"]["contributor"] = append(item.Extensions["atom"]["contributor"], personExtension("contributor", contributor))
+		}
+	}
+
+	return result, nil
+}
+
+// The parser for all feeds.
+func NewFeedParser() *gofeed.Parser {
+	parser := gofeed.NewParser()
+	parser.AtomTranslator = &atomTranslator{}
+	return parser
+}
+
+func extensionValue(extension ext.Extension, name string) string {
+	for _, child := range extension.Children[name] {
+		if child.Value != "" {
+			return child.Value
+		}
+	}
+	return ""
+}
+
+// The contributors from Atom feeds and from <dc:contributor> of RSS feeds.
+func ItemContributors(item *gofeed.Item) []*gofeed.Person {
+	var contributors []*gofeed.Person
+	for _, extension := range item.Extensions["atom"]["contributor"] {
+		contributor := &gofeed.Person{
+			Name:  extensionValue(extension, "name"),
+			Email: extensionValue(extension, "email"),
+		}
+		if contributor.Name != "" || contributor.Email != "" {
+			contributors = append(contributors, contributor)
+		}
+	}
+	if item.DublinCoreExt != nil {
+		for _, name := range item.DublinCoreExt.Contributor {
+			if name != "" {
+				contributors = append(contributors, &gofeed.Person{Name: name})
+			}
+		}
+	}
+	return contributors
+}
+
+// The media:thumbnail of the item, also inside media:group (e.g. YouTube).
+func ItemThumbnail(item *gofeed.Item) string {
+	media := item.Extensions["media"]
+	for _, thumbnail := range media["thumbnail"] {
+		if thumbnail.Attrs["url"] != "" {
+			return thumbnail.Attrs["url"]
+		}
+	}
+	for _, group := range media["group"] {
+		for _, thumbnail := range group.Children["thumbnail"] {
+			if thumbnail.Attrs["url"] != "" {
+				return thumbnail.Attrs["url"]
+			}
+		}
+	}
+	if item.Image != nil {
+		return item.Image.URL
+	}
+	return ""
+}
+
+// The <source> of the Atom entry if it's set, otherwise the feed itself.
+// feedUrl is used as the feed ID if the feed doesn't have one.
+func ItemOriginOf(item *gofeed.Item, feed *gofeed.Feed, feedUrl string) *ItemOrigin {
+	if item.Custom[sourceIdCustomKey] != "" || item.Custom[sourceTitleCustomKey] != "" {
+		origin := &ItemOrigin{
+			Title:    item.Custom[sourceTitleCustomKey],
+			Id:       item.Custom[sourceIdCustomKey],
+			Link:     item.Custom[sourceLinkCustomKey],
+			FeedLink: item.Custom[sourceFeedLinkCustomKey],
+		}
+		updated, err := time.Parse(time.RFC3339, item.Custom[sourceUpdatedCustomKey])
+		if err == nil {
+			origin.Updated = &updated
+		}
+		return origin
+	}
+
+	if feed == nil {
+		return nil
+	}
+	origin := &ItemOrigin{
+		Title:    feed.Title,
+		Id:       feed.Custom[feedIdCustomKey],
+		Link:     feed.Link,
+		FeedLink: feed.FeedLink,
+		Updated:  feed.UpdatedParsed,
+	}
+	if origin.FeedLink == "" {
+		origin.FeedLink = feedUrl
+	}
+	if origin.Id == "" {
+		origin.Id = origin.FeedLink
+	}
+	return origin
+}
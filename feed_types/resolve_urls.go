@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"bytes"
+	"encoding/xml"
+	"feedmash/util"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"net/url"
+	"strings"
+)
+
+// The loaders put the xml:base of the feed here (gofeed only applies it to Atom).
+const xmlBaseCustomKey = "xml:base"
+
+var htmlUrlAttributes = map[string]bool{
+	"href": true, "src": true, "poster": true, "cite": true, "action": true,
+}
+
+// Returns the xml:base of the root element combined with the xml:base of <channel>.
+func feedXmlBase(data []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+
+	var baseUrl *url.URL
+	for depth := 0; depth < 2; {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		startElement, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		depth++
+
+		for _, attr := range startElement.Attr {
+			if attr.Name.Local != "base" || (attr.Name.Space != "xml" && attr.Name.Space != "http://www.w3.org/XML/1998/namespace") {
+				continue
+			}
+			attrUrl, err := url.Parse(strings.TrimSpace(attr.Value))
+			if err != nil {
+				break
+			}
+			if baseUrl != nil {
+				attrUrl = baseUrl.ResolveReference(attrUrl)
+			}
+			baseUrl = attrUrl
+		}
+
+		// only <rss><channel> can have a second level xml:base that applies to all items
+		if startElement.Name.Local != "rss" {
+			break
+		}
+	}
+
+	if baseUrl == nil {
+		return ""
+	}
+	return baseUrl.String()
+}
+
+func isAbsoluteUrl(s string) bool {
+	urlObj, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && urlObj.Scheme != ""
+}
+
+func resolveSrcset(baseUrl *url.URL, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = resolveUrl(baseUrl, fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// The HTML is re-rendered only if there's something to resolve.
+func resolveHtmlUrls(s string, baseUrl *url.URL) string {
+	if !strings.Contains(s, "=") {
+		return s
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		return s
+	}
+
+	isChanged := false
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			for i, attr := range node.Attr {
+				switch {
+				case htmlUrlAttributes[attr.Key] && strings.TrimSpace(attr.Val) != "" && !isAbsoluteUrl(attr.Val):
+					node.Attr[i].Val = resolveUrl(baseUrl, attr.Val)
+					isChanged = true
+
+				case attr.Key == "srcset":
+					resolved := resolveSrcset(baseUrl, attr.Val)
+					if resolved != attr.Val {
+						node.Attr[i].Val = resolved
+						isChanged = true
+					}
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	for _, node := range nodes {
+		visit(node)
+	}
+	if !isChanged {
+		return s
+	}
+
+	var sb strings.Builder
+	for _, node := range nodes {
+		err = html.Render(&sb, node)
+		if err != nil {
+			util.LogWarn(err)
+			return s
+		}
+	}
+	return sb.String()
+}
+
+// Makes all URLs in the items absolute.
+// The base URL of the links is the feed's xml:base or the real feed URL,
+// and the base URL of the content is the item link.
+func ResolveFeedUrls(feed *gofeed.Feed, realUrl string) {
+	feedBaseUrl, err := url.Parse(realUrl)
+	if err != nil {
+		return
+	}
+	if xmlBase := feed.Custom[xmlBaseCustomKey]; xmlBase != "" {
+		feedBaseUrl, err = url.Parse(resolveUrl(feedBaseUrl, xmlBase))
+		if err != nil {
+			return
+		}
+	}
+
+	if feed.Link != "" {
+		feed.Link = resolveUrl(feedBaseUrl, feed.Link)
+	}
+
+	for _, item := range feed.Items {
+		if item.Link != "" {
+			item.Link = resolveUrl(feedBaseUrl, item.Link)
+		}
+		for i, link := range item.Links {
+			item.Links[i] = resolveUrl(feedBaseUrl, link)
+		}
+		for _, enclosure := range item.Enclosures {
+			if enclosure.URL != "" {
+				enclosure.URL = resolveUrl(feedBaseUrl, enclosure.URL)
+			}
+		}
+		if item.Image != nil && item.Image.URL != "" {
+			item.Image.URL = resolveUrl(feedBaseUrl, item.Image.URL)
+		}
+
+		itemBaseUrl := feedBaseUrl
+		if isAbsoluteUrl(item.Link) {
+			linkUrl, err := url.Parse(item.Link)
+			if err == nil {
+				itemBaseUrl = linkUrl
+			}
+		}
+		item.Content = resolveHtmlUrls(item.Content, itemBaseUrl)
+		item.Description = resolveHtmlUrls(item.Description, itemBaseUrl)
+	}
+}
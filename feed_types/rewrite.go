@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package feed_types
+
+import (
+	"errors"
+	"feedmash/util"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// A user-defined rule that maps a page URL to its feed URL,
+// e.g. "https://gitlab.example.com/(.+)" -> "https://gitlab.example.com/$1/-/tags?format=atom".
+type RewriteRule struct {
+	Match     *regexp.Regexp
+	Feed      string
+	ItemTitle *template.Template
+}
+
+// The data that is passed to the item title template.
+type rewriteItemData struct {
+	Title  string
+	Link   string
+	Author string
+}
+
+var rewriteRules []*RewriteRule
+
+func NewRewriteRule(match string, feed string, itemTitle string) (*RewriteRule, error) {
+	if match == "" {
+		return nil, errors.New("no \"match\" regex")
+	}
+	if feed == "" {
+		return nil, errors.New("no \"feed\" URL template")
+	}
+
+	matchRx, err := regexp.Compile(match)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &RewriteRule{
+		Match: matchRx,
+		Feed:  feed,
+	}
+
+	if itemTitle != "" {
+		rule.ItemTitle, err = template.New("itemTitle").Parse(itemTitle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rule, nil
+}
+
+func SetRewriteRules(rules []*RewriteRule) {
+	rewriteRules = rules
+}
+
+// Returns the first rule that matches the URL, and the rewritten URL.
+func FindRewriteRule(feedUrl url.URL) (*RewriteRule, *url.URL) {
+	urlStr := feedUrl.String()
+	for _, rule := range rewriteRules {
+		matches := rule.Match.FindStringSubmatchIndex(urlStr)
+		if matches == nil {
+			continue
+		}
+
+		rewrittenStr := string(rule.Match.ExpandString(nil, rule.Feed, urlStr, matches))
+		rewrittenUrl, err := url.Parse(rewrittenStr)
+		if err != nil {
+			util.LogWarn(err)
+			continue
+		}
+		return rule, rewrittenUrl
+	}
+	return nil, nil
+}
+
+func (rule *RewriteRule) itemTitle(item *feeds.Item) string {
+	data := rewriteItemData{
+		Title: item.Title,
+	}
+	if item.Link != nil {
+		data.Link = item.Link.Href
+	}
+	if item.Author != nil {
+		data.Author = item.Author.Name
+	}
+
+	var sb strings.Builder
+	err := rule.ItemTitle.Execute(&sb, data)
+	if err != nil {
+		util.LogWarn(err)
+		return item.Title
+	}
+	return sb.String()
+}
+
+// The original URL is still used to identify the source,
+// but the feed is resolved and downloaded from the rewritten one.
+func (rule *RewriteRule) wrapFuncs(funcs *FeedTypeFuncs, rewrittenUrl url.URL) *FeedTypeFuncs {
+	wrappedFuncs := *funcs
+	wrappedFuncs.RealUrl = func(_ url.URL) string {
+		return funcs.RealUrl(rewrittenUrl)
+	}
+
+	if rule.ItemTitle != nil {
+		wrappedFuncs.SourceFeedItemToOutFeedItem = func(item *gofeed.Item) *feeds.Item {
+			outItem := funcs.SourceFeedItemToOutFeedItem(item)
+			if outItem == nil {
+				return nil
+			}
+			outItem.Title = rule.itemTitle(outItem)
+			return outItem
+		}
+	}
+
+	return &wrappedFuncs
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/util"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html/template"
+	"strings"
+)
+
+// The visible header and footer of the item content that tell where the item came from.
+type itemAttribution struct {
+	header *template.Template
+	footer *template.Template
+}
+
+// The data that is passed to the attribution templates.
+type attributionTemplateData struct {
+	Source   string
+	SiteLink string
+	FeedUrl  string
+	Title    string
+	Link     string
+}
+
+func getHtmlTemplate(v *viper.Viper, key string) *template.Template {
+	text := v.GetString(key)
+	if text == "" {
+		return nil
+	}
+	tpl, err := template.New(key).Parse(text)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid \"%s\" template: %s", key, err))
+	}
+	return tpl
+}
+
+// Returns nil if there are no attribution templates.
+func getAttribution(v *viper.Viper, key string) *itemAttribution {
+	rawAttribution, ok := v.Get(key).(map[string]interface{})
+	if !ok {
+		if v.IsSet(key) {
+			panic(fmt.Sprintf("\"%s\" must be a map", key))
+		}
+		return nil
+	}
+
+	options := viper.New()
+	err := options.MergeConfigMap(rawAttribution)
+	if err != nil {
+		panic(err)
+	}
+
+	attribution := &itemAttribution{
+		header: getHtmlTemplate(options, "header"),
+		footer: getHtmlTemplate(options, "footer"),
+	}
+	if attribution.header == nil && attribution.footer == nil {
+		return nil
+	}
+	return attribution
+}
+
+func renderHtmlTemplate(tpl *template.Template, data attributionTemplateData) string {
+	var sb strings.Builder
+	err := tpl.Execute(&sb, data)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	return sb.String()
+}
+
+// Wraps the item conversion function of a source to also add the attribution to the content.
+// sourceName is empty for the items that are loaded from the output file,
+// they already have the attribution.
+func withAttribution(
+	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	attribution *itemAttribution,
+	sourceName string,
+	feed *gofeed.Feed,
+	feedUrl string,
+) func(item *gofeed.Item) *feeds.Item {
+	if attribution == nil || sourceName == "" {
+		return sourceFeedItemToOutFeedItem
+	}
+
+	siteLink := feedUrl
+	if feed != nil && feed.Link != "" {
+		siteLink = feed.Link
+	}
+
+	return func(item *gofeed.Item) *feeds.Item {
+		outItem := sourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			return nil
+		}
+
+		data := attributionTemplateData{
+			Source:   sourceName,
+			SiteLink: siteLink,
+			FeedUrl:  feedUrl,
+			Title:    outItem.Title,
+			Link:     itemLink(outItem),
+		}
+		if attribution.header != nil {
+			outItem.Content = renderHtmlTemplate(attribution.header, data) + outItem.Content
+		}
+		if attribution.footer != nil {
+			outItem.Content += renderHtmlTemplate(attribution.footer, data)
+		}
+		return outItem
+	}
+}
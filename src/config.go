@@ -4,6 +4,7 @@
 package src
 
 import (
+	"feedmash/feed_types"
 	"feedmash/util"
 	"fmt"
 	"github.com/spf13/cobra"
@@ -13,6 +14,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,16 +31,35 @@ var copyleftLicense = "AGPLv3"
 var appHomepage = "https://github.com/alkatrazstudio/feedmash"
 var authorHomepage = "https://alkatrazstudio.net"
 
+type SourceConfig struct {
+	url       string
+	options   *viper.Viper
+	filter    *itemFilter
+	transform *itemTransform
+}
+
 type Config struct {
 	filename         string
 	appId            string
 	appTitle         string
 	serverAddr       string
+	smtpServerAddr   string
+	smtpMailboxes    []string
 	outFeedFilename  string
+	outRssFilename   string
+	stateDir         string
 	outFeedId        string
 	outFeedTitle     string
 	outFeedSelfLink  string
-	sources          []string
+	sources          []SourceConfig
+	rewriteRules     []*feed_types.RewriteRule
+	filter           *itemFilter
+	transform        *itemTransform
+	sanitizer        *htmlSanitizer
+	dedup            *itemDedup
+	updates          *itemUpdates
+	podcast          *podcastProfile
+	attribution      *itemAttribution
 	userAgent        string
 	maxOutItems      int
 	initialPauseSecs int
@@ -56,11 +77,90 @@ func getStringSlice(v *viper.Viper, key string, def []string) []string {
 	return v.GetStringSlice(key)
 }
 
+// A source is either a URL or a map with the "url" key and type-specific settings.
+func getSources(v *viper.Viper, key string) []SourceConfig {
+	var sources []SourceConfig
+	rawSources, ok := v.Get(key).([]interface{})
+	if !ok {
+		return sources
+	}
+
+	for _, rawSource := range rawSources {
+		options := viper.New()
+		switch source := rawSource.(type) {
+		case string:
+			options.Set("url", source)
+
+		case map[string]interface{}:
+			err := options.MergeConfigMap(source)
+			if err != nil {
+				panic(err)
+			}
+
+		default:
+			panic(fmt.Sprintf("Invalid source: %v", rawSource))
+		}
+
+		sourceUrl := strings.TrimSpace(options.GetString("url"))
+		if sourceUrl == "" {
+			panic(fmt.Sprintf("Source without URL: %v", rawSource))
+		}
+
+		sources = append(sources, SourceConfig{
+			url:       sourceUrl,
+			options:   options,
+			filter:    getFilter(options, "filters"),
+			transform: getTransform(options, "transforms"),
+		})
+	}
+
+	return sources
+}
+
+// Each rule is a map with the "match" regex, the "feed" URL template and the optional "itemTitle" template.
+func getRewriteRules(v *viper.Viper, key string) []*feed_types.RewriteRule {
+	var rules []*feed_types.RewriteRule
+	rawRules, ok := v.Get(key).([]interface{})
+	if !ok {
+		return rules
+	}
+
+	for _, rawRule := range rawRules {
+		ruleMap, ok := rawRule.(map[string]interface{})
+		if !ok {
+			panic(fmt.Sprintf("Invalid rewrite rule: %v", rawRule))
+		}
+
+		options := viper.New()
+		err := options.MergeConfigMap(ruleMap)
+		if err != nil {
+			panic(err)
+		}
+
+		rule, err := feed_types.NewRewriteRule(
+			options.GetString("match"),
+			options.GetString("feed"),
+			options.GetString("itemTitle"),
+		)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid rewrite rule: %v: %s", rawRule, err))
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
 func getInt(v *viper.Viper, key string, def int) int {
 	v.SetDefault(key, def)
 	return v.GetInt(key)
 }
 
+func getBool(v *viper.Viper, key string, def bool) bool {
+	v.SetDefault(key, def)
+	return v.GetBool(key)
+}
+
 func dataRootDir() string {
 	usr, err := user.Current()
 	if err != nil {
@@ -117,14 +217,31 @@ func configFromFile(configFilename string) Config {
 		outFeedFilename = filepath.Join(dataDir, appId, appId+".xml")
 	}
 
+	stateDir := getString(v, "stateDir", "")
+	if stateDir == "" {
+		stateDir = filepath.Join(filepath.Dir(outFeedFilename), "state")
+	}
+
 	cfg := Config{
 		filename:         configFilename,
 		appId:            appId,
 		appTitle:         appTitle,
 		serverAddr:       getString(v, "serverAddr", "127.0.0.1:13742"),
+		smtpServerAddr:   getString(v, "smtpServerAddr", ""),
+		smtpMailboxes:    getStringSlice(v, "smtpMailboxes", []string{}),
 		outFeedFilename:  outFeedFilename,
+		outRssFilename:   getString(v, "outRssFilename", ""),
+		stateDir:         stateDir,
 		outFeedTitle:     getString(v, "outFeedTitle", appTitle),
-		sources:          getStringSlice(v, "sources", []string{}),
+		sources:          getSources(v, "sources"),
+		rewriteRules:     getRewriteRules(v, "rewriteRules"),
+		filter:           getFilter(v, "filters"),
+		transform:        getTransform(v, "transforms"),
+		sanitizer:        getSanitizer(v, "sanitize"),
+		dedup:            getDedup(v, "dedup"),
+		updates:          getUpdates(v, outFeedFilename),
+		podcast:          getPodcastProfile(v, "podcast"),
+		attribution:      getAttribution(v, "attribution"),
 		userAgent:        getString(v, "userAgent", appTitle),
 		maxOutItems:      getInt(v, "maxOutItems", 666),
 		initialPauseSecs: getInt(v, "initialPauseSecs", 1),
@@ -138,7 +255,11 @@ func configFromFile(configFilename string) Config {
 	defaultOutFeedId := cfg.appId
 	cfg.outFeedId = getString(v, "outFeedId", defaultOutFeedId)
 
-	if len(cfg.sources) == 0 {
+	if cfg.smtpServerAddr == "" {
+		cfg.smtpMailboxes = []string{}
+	}
+
+	if len(cfg.sources) == 0 && len(cfg.smtpMailboxes) == 0 {
 		panic(
 			fmt.Sprintf(
 				"No sources specified. Add sources to your config file (%s), in the \"sources\" array.",
@@ -152,6 +273,8 @@ func configFromFile(configFilename string) Config {
 
 func handleCli(callback func(Config), exampleYaml string) {
 	var printExampleConfig = false
+	var cfgFilenameFlag = ""
+	var outputFormatFlag = ""
 
 	ts, err := strconv.ParseInt(appBuildTimestamp, 10, 64)
 	if err != nil {
@@ -204,6 +327,93 @@ func handleCli(callback func(Config), exampleYaml string) {
 
 	rootCmd.Flags().BoolVar(&printExampleConfig, "print-example-config", false, "print an example config file")
 
+	var importYoutubeCmd = &cobra.Command{
+		Use:   "import-youtube <subscriptions-csv>",
+		Short: "Add YouTube channels from a Google Takeout subscriptions.csv to the config file",
+		Long: "Reads subscriptions.csv from Google Takeout (YouTube and YouTube Music),\n" +
+			"adds each channel to the \"sources\" array of the config file\n" +
+			"and writes the config file back, preserving the comments.\n" +
+			"Channels that are already in the \"sources\" array are skipped.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run: func(_ *cobra.Command, args []string) {
+			err := importYoutubeSubscriptions(cfgFilenameFlag, args[0])
+			if err != nil {
+				util.LogWarn(err)
+				os.Exit(1)
+			}
+		},
+		Example: "  " + appId + " import-youtube --config /path/to/your/config.yaml /path/to/subscriptions.csv",
+	}
+	importYoutubeCmd.Flags().StringVarP(&cfgFilenameFlag, "config", "c", "", "path to the config file")
+	_ = importYoutubeCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(importYoutubeCmd)
+
+	var resolveCmd = &cobra.Command{
+		Use:   "resolve <url>",
+		Short: "Show how a source URL is resolved to a feed URL",
+		Long: "Prints the rewrite rule that matches the URL (if any),\n" +
+			"the detected source type and the URL of the feed that will be downloaded.\n" +
+			"The rewrite rules and the source options are taken from the config file if it's specified.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run: func(_ *cobra.Command, args []string) {
+			err := resolveSource(cfgFilenameFlag, args[0])
+			if err != nil {
+				util.LogWarn(err)
+				os.Exit(1)
+			}
+		},
+		Example: "  " + appId + " resolve --config /path/to/your/config.yaml https://gitlab.example.com/group/project",
+	}
+	resolveCmd.Flags().StringVarP(&cfgFilenameFlag, "config", "c", "", "path to the config file")
+	rootCmd.AddCommand(resolveCmd)
+
+	var previewCmd = &cobra.Command{
+		Use:   "preview <url>",
+		Short: "Download a single source and show its items",
+		Long: "Downloads the source the same way it's done for the output feed and prints\n" +
+			"the resolved URL, the HTTP status, the feed format and the converted items.\n" +
+			"Each item is shown as new, already saved in the output file, or a duplicate of another item.\n" +
+			"The output file and the saved state are not changed.\n" +
+			"The rewrite rules and the source options are taken from the config file if it's specified.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run: func(_ *cobra.Command, args []string) {
+			err := previewSource(cfgFilenameFlag, args[0], outputFormatFlag)
+			if err != nil {
+				util.LogWarn(err)
+				os.Exit(1)
+			}
+		},
+		Example: "  " + appId + " preview --config /path/to/your/config.yaml --output json https://github.com/alkatrazstudio/feedmash/releases.atom",
+	}
+	previewCmd.Flags().StringVarP(&cfgFilenameFlag, "config", "c", "", "path to the config file")
+	previewCmd.Flags().StringVarP(&outputFormatFlag, "output", "o", "table", "output format: table or json")
+	rootCmd.AddCommand(previewCmd)
+
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check all sources from the config file",
+		Long: "Downloads all sources at once and reports DNS/TLS/HTTP errors, redirects,\n" +
+			"parse errors, the age of the newest item, sources with the same real URL\n" +
+			"and items without their own link.\n" +
+			"The age of the newest item is not checked for the types that only report changes\n" +
+			"(sitemap, watch, ics, telegram).\n" +
+			"Exits with a non-zero code if any source is broken.",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+		Run: func(_ *cobra.Command, _ []string) {
+			if !runDoctor(cfgFilenameFlag) {
+				os.Exit(1)
+			}
+		},
+		Example: "  " + appId + " doctor --config /path/to/your/config.yaml",
+	}
+	doctorCmd.Flags().StringVarP(&cfgFilenameFlag, "config", "c", "", "path to the config file")
+	_ = doctorCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(doctorCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,356 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/feed_types"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Titles with fewer words are too generic to compare, e.g. "Release notes".
+const dedupMinTitleWords = 4
+
+var dedupWordRx = regexp.MustCompile(`[\p{L}\p{N}]+`)
+var alsoSeenInRx = regexp.MustCompile(`<p><i>Also seen in: ([^<]*)</i></p>$`)
+
+// Where the item came from. All fields are empty for the items that are loaded from the output file,
+// their source is only known by the key in their ID.
+type itemSource struct {
+	name string
+	url  string
+	feed *gofeed.Feed
+}
+
+type dedupItemInfo struct {
+	links       map[string]bool
+	titleWords  map[string]bool
+	primary     itemSource
+	primaryKey  string
+	mergedIds   map[string]bool
+	alsoSeenIn  []string
+	baseContent string
+}
+
+// Merges the same item that comes from different sources into one item.
+type itemDedup struct {
+	titleSimilarity float64
+	primarySources  []string
+	infos           map[string]*dedupItemInfo
+	changed         bool
+}
+
+func getDedup(v *viper.Viper, key string) *itemDedup {
+	options := viper.New()
+	switch rawOptions := v.Get(key).(type) {
+	case nil:
+		return nil
+
+	case map[string]interface{}:
+		err := options.MergeConfigMap(rawOptions)
+		if err != nil {
+			panic(err)
+		}
+
+	default:
+		panic(fmt.Sprintf("\"%s\" must be a map", key))
+	}
+
+	options.SetDefault("enabled", true)
+	if !options.GetBool("enabled") {
+		return nil
+	}
+
+	options.SetDefault("titleSimilarity", 0.8)
+	return &itemDedup{
+		titleSimilarity: options.GetFloat64("titleSimilarity"),
+		primarySources:  getStringSlice(options, "primarySources", []string{}),
+		infos:           map[string]*dedupItemInfo{},
+	}
+}
+
+// Makes the links that point to the same page equal:
+// no scheme, no "www.", no tracking parameters, no fragment, no trailing slash, sorted query.
+func normalizeLink(link string) string {
+	urlObj, err := url.Parse(stripTrackingParams(strings.TrimSpace(link)))
+	if err != nil || urlObj.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(urlObj.Hostname()), "www.")
+	port := urlObj.Port()
+	if port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	query := urlObj.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	normalized := host + strings.TrimRight(urlObj.EscapedPath(), "/")
+	if len(params) > 0 {
+		normalized += "?" + strings.Join(params, "&")
+	}
+	return normalized
+}
+
+// The item link, the other links of the item, and the original link of FeedBurner feeds.
+func itemLinks(item *gofeed.Item, outItem *feeds.Item) map[string]bool {
+	var links []string
+	if outItem.Link != nil {
+		links = append(links, outItem.Link.Href)
+	}
+	links = append(links, item.Link)
+	links = append(links, item.Links...)
+	for _, ext := range item.Extensions["feedburner"]["origLink"] {
+		links = append(links, ext.Value)
+	}
+
+	normalizedLinks := map[string]bool{}
+	for _, link := range links {
+		normalizedLink := normalizeLink(link)
+		if normalizedLink != "" {
+			normalizedLinks[normalizedLink] = true
+		}
+	}
+	return normalizedLinks
+}
+
+func titleWords(title string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range dedupWordRx.FindAllString(strings.ToLower(title), -1) {
+		words[word] = true
+	}
+	return words
+}
+
+// Jaccard index of the title words.
+func titleSimilarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) < dedupMinTitleWords || len(b) < dedupMinTitleWords {
+		return 0
+	}
+	nCommon := 0
+	for word := range a {
+		if b[word] {
+			nCommon++
+		}
+	}
+	return float64(nCommon) / float64(len(a)+len(b)-nCommon)
+}
+
+// Lower is better; the sources that are not in primarySources are the last.
+// The saved items only have the key of their source (and the name from the <source> element).
+func (dedup *itemDedup) priority(name string, key string) int {
+	for i, primarySource := range dedup.primarySources {
+		if primarySource == name || sourceKey(primarySource) == key {
+			return i
+		}
+	}
+	return len(dedup.primarySources)
+}
+
+// The different items of the primary source are never merged (e.g. the changes of a watched page share its link),
+// and neither are the items whose source is unknown.
+func (info *dedupItemInfo) matches(other *dedupItemInfo, minTitleSimilarity float64) bool {
+	if info.primaryKey == "" || other.primaryKey == "" || info.primaryKey == other.primaryKey {
+		return false
+	}
+	for link := range other.links {
+		if info.links[link] {
+			return true
+		}
+	}
+	return minTitleSimilarity > 0 && titleSimilarity(info.titleWords, other.titleWords) >= minTitleSimilarity
+}
+
+func (info *dedupItemInfo) addAlsoSeenIn(name string) {
+	if name == "" || name == info.primary.name {
+		return
+	}
+	for _, existingName := range info.alsoSeenIn {
+		if existingName == name {
+			return
+		}
+	}
+	info.alsoSeenIn = append(info.alsoSeenIn, name)
+}
+
+func (info *dedupItemInfo) render(outItem *feeds.Item) {
+	outItem.Content = info.baseContent
+	if len(info.alsoSeenIn) > 0 {
+		outItem.Content += "<p><i>Also seen in: " + html.EscapeString(strings.Join(info.alsoSeenIn, ", ")) + "</i></p>"
+	}
+}
+
+func newDedupItemInfo(item *gofeed.Item, outItem *feeds.Item, source itemSource) *dedupItemInfo {
+	info := &dedupItemInfo{
+		links:       itemLinks(item, outItem),
+		titleWords:  titleWords(outItem.Title),
+		primary:     source,
+		primaryKey:  itemSourceKey(source.url, outItem.Id),
+		mergedIds:   map[string]bool{},
+		baseContent: outItem.Content,
+	}
+	if info.primary.name == "" {
+		// the saved items have the title of their source in the <source> element
+		if origin := feed_types.ItemOriginOf(item, nil, ""); origin != nil {
+			info.primary.name = origin.Title
+		}
+	}
+
+	// the saved items already have the attribution
+	matches := alsoSeenInRx.FindStringSubmatch(outItem.Content)
+	if matches != nil {
+		info.baseContent = outItem.Content[:len(outItem.Content)-len(matches[0])]
+		for _, name := range strings.Split(html.UnescapeString(matches[1]), ", ") {
+			info.addAlsoSeenIn(name)
+		}
+	}
+
+	return info
+}
+
+// Returns true if the item is a duplicate of one of the items,
+// in which case it's merged into that item.
+func (dedup *itemDedup) mergeDuplicate(
+	items []*feeds.Item,
+	item *gofeed.Item,
+	outItem *feeds.Item,
+	source itemSource,
+	extras itemExtras,
+) bool {
+	for _, existingItem := range items {
+		if existingItem.Id == outItem.Id {
+			// the source of a legacy item is known once the source reports it (see legacyItemIds)
+			if info := dedup.infos[outItem.Id]; info != nil && info.primaryKey == "" {
+				info.primaryKey = itemSourceKey(source.url, outItem.Id)
+			}
+			return false
+		}
+	}
+
+	// the sources report the same items on each download
+	for _, existingItem := range items {
+		info := dedup.infos[existingItem.Id]
+		if info != nil && info.mergedIds[outItem.Id] {
+			return true
+		}
+	}
+
+	newInfo := newDedupItemInfo(item, outItem, source)
+	for _, existingItem := range items {
+		info := dedup.infos[existingItem.Id]
+		if info == nil || !info.matches(newInfo, dedup.titleSimilarity) {
+			continue
+		}
+
+		for link := range newInfo.links {
+			info.links[link] = true
+		}
+		info.mergedIds[outItem.Id] = true
+		oldTitle := existingItem.Title
+		oldContent := existingItem.Content
+
+		if dedup.priority(newInfo.primary.name, newInfo.primaryKey) < dedup.priority(info.primary.name, info.primaryKey) {
+			// keep the ID and the date, so the item doesn't appear as a new one
+			oldPrimaryName := info.primary.name
+			info.primary = newInfo.primary
+			info.primaryKey = newInfo.primaryKey
+			info.addAlsoSeenIn(oldPrimaryName)
+			info.baseContent = newInfo.baseContent
+			info.titleWords = newInfo.titleWords
+			existingItem.Title = outItem.Title
+			existingItem.Link = outItem.Link
+			existingItem.Description = outItem.Description
+			existingItem.Author = outItem.Author
+			existingItem.Enclosure = outItem.Enclosure
+			extras.set(existingItem.Id, item, source)
+			for _, name := range newInfo.alsoSeenIn {
+				info.addAlsoSeenIn(name)
+			}
+			info.alsoSeenIn = removeString(info.alsoSeenIn, info.primary.name)
+		} else {
+			info.addAlsoSeenIn(newInfo.primary.name)
+			for _, name := range newInfo.alsoSeenIn {
+				info.addAlsoSeenIn(name)
+			}
+		}
+
+		info.render(existingItem)
+		dedup.changed = dedup.changed || existingItem.Content != oldContent || existingItem.Title != oldTitle
+		return true
+	}
+
+	dedup.infos[outItem.Id] = newInfo
+	return false
+}
+
+// Sets the sources of the legacy items that were learned before (the items are loaded with no source).
+func (dedup *itemDedup) restoreLegacySourceKeys(legacyIds *legacyItemIds) {
+	if dedup == nil {
+		return
+	}
+	for id, info := range dedup.infos {
+		if info.primaryKey == "" {
+			info.primaryKey = legacyIds.sourceKey(id)
+		}
+	}
+}
+
+// Replaces the content of the item, keeping the "Also seen in" line.
+func (dedup *itemDedup) setContent(item *feeds.Item, content string) {
+	if dedup == nil || dedup.infos[item.Id] == nil {
+		item.Content = content
+		return
+	}
+	info := dedup.infos[item.Id]
+	info.baseContent = content
+	info.render(item)
+}
+
+func removeString(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Forgets the items that are no longer in the output feed.
+func (dedup *itemDedup) prune(items []*feeds.Item) {
+	ids := map[string]bool{}
+	for _, item := range items {
+		ids[item.Id] = true
+	}
+	for id := range dedup.infos {
+		if !ids[id] {
+			delete(dedup.infos, id)
+		}
+	}
+}
+
+// Returns true if any existing item was changed since the last call.
+func (dedup *itemDedup) takeChanged() bool {
+	changed := dedup.changed
+	dedup.changed = false
+	return changed
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/feed_types"
+	"feedmash/util"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSourceA = "https://a.example.com/feed.xml"
+	testSourceB = "https://b.example.com/feed.xml"
+)
+
+type testPoller struct {
+	t         *testing.T
+	cfg       Config
+	extras    itemExtras
+	items     []*feeds.Item
+	legacyIds *legacyItemIds
+}
+
+func newTestPoller(t *testing.T, cfg Config) *testPoller {
+	if cfg.maxOutItems == 0 {
+		cfg.maxOutItems = 100
+	}
+	oldStateDir := util.StateDir()
+	util.SetStateDir(t.TempDir())
+	t.Cleanup(func() {
+		util.SetStateDir(oldStateDir)
+	})
+	return &testPoller{
+		t:         t,
+		cfg:       cfg,
+		extras:    itemExtras{},
+		items:     []*feeds.Item{},
+		legacyIds: newLegacyItemIds(nil, "legacyids"),
+	}
+}
+
+// Merges the items of the source like the receiver does on each download.
+func (poller *testPoller) poll(sourceUrl string, name string, items ...*gofeed.Item) {
+	feedSource := FeedSource{
+		url:   sourceUrl,
+		name:  name,
+		funcs: &feed_types.FeedTypeFuncs{SourceFeedItemToOutFeedItem: feed_types.HttpSourceFeedItemToOutFeedItem},
+	}
+	feed := &gofeed.Feed{Title: name, Items: items}
+	poller.items = mergeOutFeedItems(
+		poller.items,
+		items,
+		poller.cfg.maxOutItems,
+		sourceItemConverter(feedSource, feed, poller.cfg, poller.legacyIds),
+		poller.cfg.dedup,
+		poller.cfg.updates,
+		poller.extras,
+		feedSource.itemSource(feed),
+		poller.cfg.updates.isResurfacing(),
+	)
+	poller.legacyIds.prune(poller.items)
+	poller.legacyIds.save()
+}
+
+// Writes the output feed and loads it again, like after a restart.
+func (poller *testPoller) restart() {
+	outFeed := &feeds.Feed{Title: "out", Link: &feeds.Link{Href: "https://out.example.com/", Rel: "self"}, Items: poller.items, Updated: time.Now()}
+	outXml := feedToXml(outFeed, poller.extras, poller.cfg)
+	outFeedData, err := feed_types.NewFeedParser().ParseString(outXml.atom)
+	if err != nil {
+		poller.t.Fatal(err)
+	}
+	if poller.cfg.dedup != nil {
+		poller.cfg.dedup.infos = map[string]*dedupItemInfo{}
+	}
+	poller.extras = itemExtras{}
+	poller.items = reloadOutFeedItems(outFeedData, poller.cfg, poller.extras)
+	poller.legacyIds = newLegacyItemIds(poller.items, "legacyids")
+	poller.cfg.dedup.restoreLegacySourceKeys(poller.legacyIds)
+}
+
+func (poller *testPoller) expectItems(step string, titles ...string) {
+	var gotTitles []string
+	for _, item := range poller.items {
+		gotTitles = append(gotTitles, item.Title)
+	}
+	if strings.Join(gotTitles, "|") != strings.Join(titles, "|") {
+		poller.t.Fatalf("%s: got %q, expected %q", step, gotTitles, titles)
+	}
+}
+
+func testItem(guid string, title string, link string, day int) *gofeed.Item {
+	date := time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC)
+	return &gofeed.Item{GUID: guid, Title: title, Link: link, Content: "<p>" + title + "</p>", PublishedParsed: &date}
+}
+
+func TestNormalizeLink(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{"https://www.example.com/post/", "example.com/post"},
+		{"http://example.com/post#comments", "example.com/post"},
+		{"https://example.com:443/post?b=2&a=1&utm_source=rss", "example.com/post?a=1&b=2"},
+		{"https://example.com:8080/post", "example.com:8080/post"},
+		{"/relative", ""},
+	}
+	for _, test := range tests {
+		got := normalizeLink(test.link)
+		if got != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.link, got, test.expected)
+		}
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		similar bool
+	}{
+		{"Go 1.22 is released with new features", "Go 1.22 is released, with new features!", true},
+		{"Go 1.22 is released with new features", "Rust 1.77 is released with new features", false},
+		{"Release notes", "Release notes", false},
+	}
+	for _, test := range tests {
+		similar := titleSimilarity(titleWords(test.a), titleWords(test.b)) >= 0.8
+		if similar != test.similar {
+			t.Errorf("%q vs %q: expected %v", test.a, test.b, test.similar)
+		}
+	}
+}
+
+func TestDedupMultiplePolls(t *testing.T) {
+	poller := newTestPoller(t, Config{dedup: &itemDedup{titleSimilarity: 0.8, infos: map[string]*dedupItemInfo{}}})
+	a1 := testItem("a1", "Story one", "https://news.example.com/1", 1)
+	b1 := testItem("b1", "Story one (B)", "https://www.news.example.com/1/?utm_source=b", 2)
+
+	poller.poll(testSourceA, "A", a1)
+	poller.poll(testSourceB, "B", b1)
+	poller.expectItems("merged", "Story one")
+	if !strings.Contains(poller.items[0].Content, "Also seen in: B") {
+		t.Errorf("no attribution: %s", poller.items[0].Content)
+	}
+
+	// the sources report the same items on each download
+	for i := 0; i < 3; i++ {
+		poller.poll(testSourceB, "B", b1)
+		poller.poll(testSourceA, "A", a1)
+		poller.expectItems("next polls", "Story one")
+	}
+	if !poller.cfg.dedup.takeChanged() {
+		t.Error("the first merge must change the item")
+	}
+	poller.poll(testSourceB, "B", b1)
+	if poller.cfg.dedup.takeChanged() {
+		t.Error("the merged item is changed again")
+	}
+
+	// a different item of the primary source with the same link is not merged (e.g. a watched page)
+	a2 := testItem("a2", "Story one, updated", "https://news.example.com/1", 3)
+	poller.poll(testSourceA, "A", a1, a2)
+	poller.expectItems("same source", "Story one, updated", "Story one")
+
+	poller.restart()
+	poller.expectItems("restart", "Story one, updated", "Story one")
+	poller.poll(testSourceB, "B", b1)
+	poller.poll(testSourceA, "A", a1, a2)
+	poller.expectItems("after restart", "Story one, updated", "Story one")
+	if strings.Count(poller.items[1].Content, "Also seen in") != 1 {
+		t.Errorf("the attribution must not be repeated: %s", poller.items[1].Content)
+	}
+}
+
+func TestDedupPrimarySource(t *testing.T) {
+	dedup := &itemDedup{primarySources: []string{testSourceB}, infos: map[string]*dedupItemInfo{}}
+	poller := newTestPoller(t, Config{dedup: dedup})
+	a1 := testItem("a1", "Story from A", "https://news.example.com/1", 1)
+	b1 := testItem("b1", "Story from B", "https://news.example.com/1", 2)
+
+	poller.poll(testSourceA, "A", a1)
+	id := poller.items[0].Id
+	poller.poll(testSourceB, "B", b1)
+	poller.expectItems("primary source", "Story from B")
+	if poller.items[0].Id != id {
+		t.Error("the ID of the merged item must be kept")
+	}
+	if !strings.Contains(poller.items[0].Content, "Also seen in: A") {
+		t.Errorf("no attribution: %s", poller.items[0].Content)
+	}
+
+	for i := 0; i < 2; i++ {
+		poller.poll(testSourceA, "A", a1)
+		poller.poll(testSourceB, "B", b1)
+		poller.expectItems("next polls", "Story from B")
+	}
+
+	poller.restart()
+	poller.poll(testSourceA, "A", a1)
+	poller.poll(testSourceB, "B", b1)
+	poller.expectItems("after restart", "Story from B")
+}
+
+func TestDedupLegacyItems(t *testing.T) {
+	poller := newTestPoller(t, Config{dedup: &itemDedup{infos: map[string]*dedupItemInfo{}}})
+	a1 := testItem("a1", "Story from A", "https://news.example.com/1", 1)
+	b1 := testItem("b1", "Story from B", "https://news.example.com/1", 2)
+
+	// saved before the IDs became source-scoped
+	poller.items = []*feeds.Item{feed_types.HttpSourceFeedItemToOutFeedItem(a1)}
+	poller.restart()
+
+	// the source of the saved item is learned when the source reports it, and the item keeps its ID
+	poller.poll(testSourceA, "A", a1)
+	poller.expectItems("legacy item", "Story from A")
+	if poller.items[0].Id != "a1" {
+		t.Errorf("the legacy ID must be kept: %s", poller.items[0].Id)
+	}
+
+	// the source is remembered after a restart
+	poller.restart()
+	poller.poll(testSourceB, "B", b1)
+	poller.expectItems("merged", "Story from A")
+	if !strings.Contains(poller.items[0].Content, "Also seen in: B") {
+		t.Errorf("no attribution: %s", poller.items[0].Content)
+	}
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"feedmash/feed_types"
+	"feedmash/util"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const doctorMaxParallel = 8
+const doctorMaxRedirects = 10
+const doctorTimeout = 30 * time.Second
+
+const (
+	doctorOk = iota
+	doctorWarn
+	doctorFail
+)
+
+var doctorStatusNames = []string{"OK", "WARN", "FAIL"}
+
+type doctorReport struct {
+	url     string
+	realUrl string
+	status  int
+	notes   []string
+}
+
+func (report *doctorReport) note(status int, format string, args ...interface{}) {
+	report.status = max(report.status, status)
+	report.notes = append(report.notes, fmt.Sprintf(format, args...))
+}
+
+func formatAge(d time.Duration) string {
+	if d < 0 {
+		return "in the future"
+	}
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+	return d.Round(time.Minute).String() + " ago"
+}
+
+func describeNetError(err error) string {
+	var dnsErr *net.DNSError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "DNS: " + dnsErr.Error()
+	case errors.As(err, &unknownAuthorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certInvalidErr),
+		errors.As(err, &recordHeaderErr):
+		return "TLS: " + err.Error()
+	default:
+		return "HTTP: " + err.Error()
+	}
+}
+
+// Follows the redirects manually to report each of them.
+func (report *doctorReport) checkHttp(realUrl string, userAgent string) {
+	client := &http.Client{
+		Timeout: doctorTimeout,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	reqUrl := realUrl
+	isPermanent := true
+	for i := 0; ; i++ {
+		if i >= doctorMaxRedirects {
+			report.note(doctorFail, "too many redirects")
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+		if err != nil {
+			report.note(doctorFail, "HTTP: %s", err)
+			return
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			report.note(doctorFail, "%s", describeNetError(err))
+			return
+		}
+		_ = resp.Body.Close()
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				report.note(doctorFail, "HTTP: %s", resp.Status)
+				return
+			}
+			if reqUrl != realUrl && isPermanent {
+				report.note(doctorWarn, "permanently redirected, use %s", reqUrl)
+			}
+			return
+		}
+
+		report.note(doctorOk, "redirect: %s -> %s", resp.Status, location)
+		if resp.StatusCode != http.StatusMovedPermanently && resp.StatusCode != http.StatusPermanentRedirect {
+			isPermanent = false
+		}
+
+		locationUrl, err := url.Parse(location)
+		if err != nil {
+			report.note(doctorFail, "HTTP: invalid redirect: %s", err)
+			return
+		}
+		reqUrl = req.URL.ResolveReference(locationUrl).String()
+	}
+}
+
+func checkSource(sourceCfg SourceConfig, cfg Config) *doctorReport {
+	report := &doctorReport{url: sourceCfg.url}
+
+	urlObj, err := url.Parse(sourceCfg.url)
+	if err != nil {
+		report.note(doctorFail, "invalid URL: %s", err)
+		return report
+	}
+
+	// downloading all the articles would take too long
+	feedType, funcs := feed_types.DetectWithoutFulltext(*urlObj, sourceCfg.options)
+	if feedType == feed_types.Unknown {
+		report.note(doctorFail, "can't determine the source type")
+		return report
+	}
+	report.note(doctorOk, "type: %s", feed_types.TypeName(feedType))
+	if sourceCfg.options.GetBool("fulltext") {
+		report.note(doctorOk, "full text extraction is not checked")
+	}
+
+	report.realUrl = funcs.RealUrl(*urlObj)
+	if report.realUrl == "" {
+		report.note(doctorFail, "can't get the real URL")
+		return report
+	}
+	if report.realUrl != sourceCfg.url {
+		report.note(doctorOk, "real URL: %s", report.realUrl)
+	}
+
+	realUrlObj, err := url.Parse(report.realUrl)
+	if err == nil && feed_types.IsHttp(*realUrlObj) {
+		report.checkHttp(report.realUrl, cfg.userAgent)
+		if report.status == doctorFail {
+			return report
+		}
+	}
+
+	feed, err := funcs.LoadFeed(report.realUrl, cfg.userAgent)
+	if err != nil {
+		report.note(doctorFail, "can't load the feed: %s", err)
+		return report
+	}
+	// nothing is written, so the state of the source stays as it was
+	feed_types.TakeFeedState(feed)
+	feed_types.ResolveFeedUrls(feed, report.realUrl)
+	nWithoutLinks := feed_types.FillMissingLinks(feed)
+	feed_types.FillMissingDates(feed, report.realUrl)
+
+	var newest time.Time
+	nItems := 0
+	nDropped := 0
+	for _, item := range feed.Items {
+		outItem := funcs.SourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			nDropped++
+			continue
+		}
+		nItems++
+		if outItem.Created.After(newest) {
+			newest = outItem.Created
+		}
+	}
+
+	switch {
+	case feed_types.IsStateful(feedType):
+		report.note(doctorOk, "items: %d", nItems)
+	case nItems == 0:
+		report.note(doctorWarn, "no items")
+	default:
+		report.note(doctorOk, "items: %d, newest: %s", nItems, formatAge(time.Since(newest)))
+	}
+	if nWithoutLinks > 0 {
+		report.note(doctorOk, "items without their own link: %d", nWithoutLinks)
+	}
+	if nDropped > 0 {
+		report.note(doctorWarn, "items that can't be converted: %d", nDropped)
+	}
+
+	return report
+}
+
+func checkDuplicateSources(reports []*doctorReport) {
+	byRealUrl := map[string][]*doctorReport{}
+	for _, report := range reports {
+		if report.realUrl != "" {
+			byRealUrl[report.realUrl] = append(byRealUrl[report.realUrl], report)
+		}
+	}
+
+	for realUrl, dupReports := range byRealUrl {
+		if len(dupReports) < 2 {
+			continue
+		}
+		for _, report := range dupReports {
+			var others []string
+			for _, otherReport := range dupReports {
+				if otherReport != report {
+					others = append(others, otherReport.url)
+				}
+			}
+			report.note(doctorWarn, "%s is also used by: %s", realUrl, strings.Join(others, ", "))
+		}
+	}
+}
+
+// Checks all sources and returns false if any of them is broken.
+func runDoctor(cfgFilename string) bool {
+	cfg := configFromFile(cfgFilename)
+	feed_types.SetRewriteRules(cfg.rewriteRules)
+
+	// some types remember what they've already seen, don't let the check affect that
+	stateDir, err := copyStateDir(cfg.stateDir)
+	if stateDir != "" {
+		defer func() {
+			if err := os.RemoveAll(stateDir); err != nil {
+				util.LogWarn(err)
+			}
+		}()
+	}
+	if err != nil {
+		util.LogWarn(err)
+		return false
+	}
+	util.SetStateDir(stateDir)
+
+	// the sources are loaded with the default client, which waits forever
+	oldClient := http.DefaultClient
+	http.DefaultClient = &http.Client{Timeout: doctorTimeout}
+	defer func() {
+		http.DefaultClient = oldClient
+	}()
+
+	reports := make([]*doctorReport, len(cfg.sources))
+	var wg sync.WaitGroup
+	semaphore := make(chan bool, doctorMaxParallel)
+	for i, sourceCfg := range cfg.sources {
+		wg.Add(1)
+		go func(i int, sourceCfg SourceConfig) {
+			defer wg.Done()
+			semaphore <- true
+			reports[i] = checkSource(sourceCfg, cfg)
+			<-semaphore
+		}(i, sourceCfg)
+	}
+	wg.Wait()
+
+	checkDuplicateSources(reports)
+
+	counts := make([]int, len(doctorStatusNames))
+	for _, report := range reports {
+		counts[report.status]++
+		util.LogInfo(fmt.Sprintf("[%s] %s", doctorStatusNames[report.status], report.url))
+		for _, note := range report.notes {
+			util.LogInfo("    " + note)
+		}
+	}
+
+	util.LogInfo("")
+	util.LogInfo(fmt.Sprintf(
+		"Sources: %d, OK: %d, with warnings: %d, failed: %d",
+		len(reports), counts[doctorOk], counts[doctorWarn], counts[doctorFail],
+	))
+
+	return counts[doctorFail] == 0
+}
@@ -4,26 +4,35 @@
 package src
 
 import (
+	"feedmash/feed_types"
 	"feedmash/util"
 	"os"
 	"os/signal"
 )
 
 func run(cfg Config) {
+	util.SetStateDir(cfg.stateDir)
+	feed_types.SetRewriteRules(cfg.rewriteRules)
+
 	nSources := len(cfg.sources)
 	sourceFeedsChan := make(chan *FeedChanItem, nSources)
 	sourceFeedsReceiverStopped := make(chan bool)
-	outXmlChan := make(chan string)
+	outXmlChan := make(chan outFeedXml)
 	go startSourceFeedsReceiver(cfg, sourceFeedsChan, sourceFeedsReceiverStopped, outXmlChan)
 
 	feedSources := loadSources(cfg.sources)
 	go startWatchingFeeds(feedSources, cfg, sourceFeedsChan)
 
+	var smtpSrv *smtpServer = nil
+	if len(cfg.smtpMailboxes) > 0 {
+		smtpSrv = startSmtpServer(cfg.smtpServerAddr, cfg.smtpMailboxes, sourceFeedsChan)
+	}
+
 	srvStop := make(chan bool)
 	srvStopped := make(chan bool)
 	go runServer(cfg.serverAddr, srvStop, srvStopped, outXmlChan)
 
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	sourceFeedsReceiverIsStopped := false
 	select {
@@ -39,6 +48,9 @@ func run(cfg Config) {
 		sourceFeedsReceiverIsStopped = true
 	}
 
+	if smtpSrv != nil {
+		smtpSrv.stop()
+	}
 	sourceFeedsChan <- nil
 	srvStop <- true
 	stopWatchingFeeds(feedSources)
@@ -4,7 +4,6 @@
 package src
 
 import (
-	"encoding/xml"
 	"feedmash/feed_types"
 	"feedmash/util"
 	"fmt"
@@ -13,56 +12,81 @@ import (
 	"math/rand"
 	"net/url"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 )
 
 type FeedSource struct {
-	url      string
-	urlObj   url.URL
-	feedType int
-	funcs    *feed_types.FeedTypeFuncs
-	realUrl  string
-	stop     chan bool
-	stopped  chan bool
+	url       string
+	urlObj    url.URL
+	feedType  int
+	funcs     *feed_types.FeedTypeFuncs
+	realUrl   string
+	name      string
+	filter    *itemFilter
+	transform *itemTransform
+	stop      chan bool
+	stopped   chan bool
 }
 
 type FeedChanItem struct {
 	source FeedSource
 	feed   gofeed.Feed
+	// saves the state of the source after its items are written (may be nil)
+	commitState func()
 }
 
-func newFeedSource(feedUrl string) *FeedSource {
+func (chanItem *FeedChanItem) saveState() {
+	if chanItem.commitState != nil {
+		chanItem.commitState()
+	}
+}
+
+func newFeedSource(sourceCfg SourceConfig) *FeedSource {
+	feedUrl := sourceCfg.url
 	urlObj, err := url.Parse(feedUrl)
 	if err != nil {
 		util.LogWarn(err)
 		return nil
 	}
 
-	feedType, funcs := feed_types.Detect(*urlObj)
+	feedType, funcs := feed_types.Detect(*urlObj, sourceCfg.options)
 	if feedType == feed_types.Unknown {
 		return nil
 	}
 
 	source := FeedSource{
-		url:      feedUrl,
-		urlObj:   *urlObj,
-		feedType: feedType,
-		funcs:    funcs,
-		realUrl:  "",
-		stop:     make(chan bool),
-		stopped:  make(chan bool),
+		url:       feedUrl,
+		urlObj:    *urlObj,
+		feedType:  feedType,
+		funcs:     funcs,
+		realUrl:   "",
+		name:      sourceCfg.options.GetString("name"),
+		filter:    sourceCfg.filter,
+		transform: sourceCfg.transform,
+		stop:      make(chan bool),
+		stopped:   make(chan bool),
 	}
 
 	return &source
 }
 
-func loadSourceFeed(feedSource FeedSource, cfg Config) *gofeed.Feed {
-	fp := gofeed.NewParser()
-	fp.UserAgent = cfg.userAgent
+// The name from the config, or the title of the feed, or the host name.
+func (feedSource FeedSource) displayName(feed *gofeed.Feed) string {
+	if feedSource.name != "" {
+		return feedSource.name
+	}
+	if feed != nil && strings.TrimSpace(feed.Title) != "" {
+		return strings.TrimSpace(feed.Title)
+	}
+	if feedSource.urlObj.Host != "" {
+		return feedSource.urlObj.Host
+	}
+	return feedSource.url
+}
 
+func loadSourceFeed(feedSource FeedSource, cfg Config) *gofeed.Feed {
 	if feedSource.realUrl == "" {
 		feedSource.realUrl = feedSource.funcs.RealUrl(feedSource.urlObj)
 		if feedSource.realUrl == "" {
@@ -71,15 +95,18 @@ func loadSourceFeed(feedSource FeedSource, cfg Config) *gofeed.Feed {
 		}
 	}
 
-	feed, err := fp.ParseURL(feedSource.realUrl)
+	feed, err := feedSource.funcs.LoadFeed(feedSource.realUrl, cfg.userAgent)
 	if err != nil {
 		util.LogWarn(fmt.Sprintf("%s (%s) %s", feedSource.realUrl, feedSource.url, err))
 		return nil
 	}
+	feed_types.ResolveFeedUrls(feed, feedSource.realUrl)
+	feed_types.FillMissingLinks(feed)
+	feed_types.FillMissingDates(feed, feedSource.realUrl)
 	return feed
 }
 
-func appendFeedItem(curOutItems []*feeds.Item, item *feeds.Item) []*feeds.Item {
+func appendFeedItem(curOutItems []*feeds.Item, item *feeds.Item, byUpdated bool) []*feeds.Item {
 	for _, outFeedItem := range curOutItems {
 		if outFeedItem.Id == item.Id {
 			return curOutItems
@@ -87,13 +114,25 @@ func appendFeedItem(curOutItems []*feeds.Item, item *feeds.Item) []*feeds.Item {
 	}
 
 	items := append(curOutItems, item)
-	sort.Slice(items, func(a, b int) bool {
-		return items[a].Created.After(items[b].Created)
-	})
-
+	sortFeedItems(items, byUpdated)
 	return items
 }
 
+// The updated items are moved to the top if byUpdated is set (see resurfaceUpdated in the config).
+func outItemSortDate(item *feeds.Item, byUpdated bool) time.Time {
+	if byUpdated && item.Updated.After(item.Created) {
+		return item.Updated
+	}
+	return item.Created
+}
+
+func sortFeedItems(items []*feeds.Item, byUpdated bool) {
+	// keep the order of the items with the same date, so the new ones don't push out the old ones
+	sort.SliceStable(items, func(a, b int) bool {
+		return outItemSortDate(items[a], byUpdated).After(outItemSortDate(items[b], byUpdated))
+	})
+}
+
 func randDurationInRange(minMins int, maxMins int) time.Duration {
 	mins := rand.Int63n(int64(maxMins-minMins)) + int64(minMins)
 	interval := time.Duration(mins)
@@ -115,8 +154,9 @@ func watchFeed(feedSource FeedSource, cfg Config, sourceFeedsChan chan *FeedChan
 				break
 			}
 			sourceFeedsChan <- &FeedChanItem{
-				source: feedSource,
-				feed:   *feed,
+				source:      feedSource,
+				feed:        *feed,
+				commitState: feed_types.TakeFeedState(feed),
 			}
 			newInterval := randDurationInRange(cfg.minIntervalMins, cfg.maxIntervalMins) * time.Minute
 			timer = time.NewTimer(newInterval)
@@ -140,10 +180,10 @@ func stopWatchingFeeds(feedSources []FeedSource) {
 	}
 }
 
-func loadSources(urls []string) []FeedSource {
+func loadSources(sourceCfgs []SourceConfig) []FeedSource {
 	var feedSources []FeedSource
-	for _, feedUrl := range urls {
-		feedSource := newFeedSource(feedUrl)
+	for _, sourceCfg := range sourceCfgs {
+		feedSource := newFeedSource(sourceCfg)
 		if feedSource == nil {
 			continue
 		}
@@ -152,95 +192,143 @@ func loadSources(urls []string) []FeedSource {
 	return feedSources
 }
 
-func saveToFile(filename string, s string) {
-	tmpFilename := filename + ".tmp"
-
-	outFeedDir := filepath.Dir(tmpFilename)
-	err := os.MkdirAll(outFeedDir, 0750)
-	if err != nil {
-		util.LogWarn(err)
-		return
-	}
-
-	f, err := os.Create(tmpFilename)
-	if err != nil {
-		util.LogWarn(err)
-		return
-	}
-
-	isClosed := false
-	defer func() {
-		if isClosed {
-			return
-		}
-		if err := f.Close(); err != nil {
-			util.LogWarn(err)
-		}
-	}()
-
-	_, err = f.WriteString(s)
-	if err != nil {
-		util.LogWarn(err)
-		return
-	}
-
-	err = f.Close()
-	if err != nil {
-		util.LogWarn(err)
-		return
-	}
-	isClosed = true
-
-	err = os.Rename(tmpFilename, filename)
-	if err != nil {
-		util.LogWarn(err)
-		return
-	}
-}
-
 func mergeOutFeedItems(
 	oldItems []*feeds.Item,
 	newItems []*gofeed.Item,
 	maxOutItems int,
 	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	dedup *itemDedup,
+	updates *itemUpdates,
+	extras itemExtras,
+	source itemSource,
+	byUpdated bool,
 ) []*feeds.Item {
 	resultItems := oldItems
 
 	for _, item := range newItems {
 		outItem := sourceFeedItemToOutFeedItem(item)
-		if outItem != nil {
-			resultItems = appendFeedItem(resultItems, outItem)
+		if outItem == nil {
+			continue
+		}
+		if updates != nil && updates.mergeUpdate(resultItems, item, outItem, source, dedup, extras) {
+			continue
+		}
+		if dedup != nil && dedup.mergeDuplicate(resultItems, item, outItem, source, extras) {
+			continue
+		}
+		resultItems = appendFeedItem(resultItems, outItem, byUpdated)
+		extras.set(outItem.Id, item, source)
+		if updates != nil {
+			updates.remember(item, outItem)
 		}
 	}
 
+	if byUpdated {
+		// the updated items are changed in place
+		sortFeedItems(resultItems, byUpdated)
+	}
+
 	nItems := len(resultItems)
 	if nItems > maxOutItems {
 		resultItems = resultItems[0:maxOutItems]
 	}
 
+	if dedup != nil {
+		dedup.prune(resultItems)
+	}
+	if updates != nil {
+		updates.prune(resultItems)
+	}
+	extras.prune(resultItems)
+
 	return resultItems
 }
 
-func feedToStr(feed *feeds.Feed) string {
-	xmlInternal := feeds.Atom{Feed: feed}
-	xmlFeed := xmlInternal.AtomFeed()
-	xmlFeed.Id = feed.Id
-	data, err := xml.Marshal(xmlFeed)
+// Converts the items of the source to the output items, with all the changes that are made to them.
+func sourceItemConverter(
+	feedSource FeedSource,
+	feed *gofeed.Feed,
+	cfg Config,
+	legacyIds *legacyItemIds,
+) func(item *gofeed.Item) *feeds.Item {
+	sourceName := feedSource.displayName(feed)
+	return withScopedIds(
+		withSanitizer(
+			withAttribution(
+				withTransforms(
+					feedSource.funcs.SourceFeedItemToOutFeedItem,
+					sourceName,
+					feedSource.transform,
+					cfg.transform,
+				),
+				cfg.attribution,
+				sourceName,
+				feed,
+				feedSource.url,
+			),
+			cfg.sanitizer,
+		),
+		feedSource.url,
+		legacyIds,
+	)
+}
+
+func (feedSource FeedSource) itemSource(feed *gofeed.Feed) itemSource {
+	return itemSource{name: feedSource.displayName(feed), url: feedSource.url, feed: feed}
+}
+
+// The items of the saved output feed.
+func reloadOutFeedItems(outFeedData *gofeed.Feed, cfg Config, extras itemExtras) []*feeds.Item {
+	return mergeOutFeedItems(
+		[]*feeds.Item{},
+		outFeedData.Items,
+		cfg.maxOutItems,
+		withSanitizer(withReloadTransforms(feed_types.HttpSourceFeedItemToOutFeedItem, cfg), cfg.sanitizer),
+		cfg.dedup,
+		nil,
+		extras,
+		itemSource{},
+		cfg.updates.isResurfacing(),
+	)
+}
+
+func feedToXml(feed *feeds.Feed, extras itemExtras, cfg Config) outFeedXml {
+	return outFeedXml{
+		atom: feedToAtomStr(feed, extras),
+		rss:  feedToRssStr(feed, extras, cfg.podcast),
+	}
+}
+
+// Returns false if some file was not written.
+func saveOutFeed(cfg Config, outXml outFeedXml) bool {
+	err := util.SaveToFile(cfg.outFeedFilename, outXml.atom)
 	if err != nil {
 		util.LogWarn(err)
-		return ""
+		return false
+	}
+	if cfg.outRssFilename != "" {
+		return saveOutRss(cfg, outXml)
 	}
-	xmlStr := strings.TrimSpace(xml.Header) + string(data)
-	return xmlStr
+	return true
+}
+
+func saveOutRss(cfg Config, outXml outFeedXml) bool {
+	err := util.SaveToFile(cfg.outRssFilename, outXml.rss)
+	if err != nil {
+		util.LogWarn(err)
+		return false
+	}
+	return true
 }
 
 func startSourceFeedsReceiver(
 	cfg Config,
 	feedsChan chan *FeedChanItem,
 	sourceFeedsReceiverStopped chan bool,
-	outXmlChan chan string,
+	outXmlChan chan outFeedXml,
 ) {
 	outFeedData := loadOutFeed(cfg)
+	extras := itemExtras{}
 
 	outFeed := &feeds.Feed{
 		Id:    cfg.outFeedId,
@@ -254,12 +342,7 @@ func startSourceFeedsReceiver(
 
 	changed := true
 	if outFeedData != nil {
-		outFeed.Items = mergeOutFeedItems(
-			outFeed.Items,
-			outFeedData.Items,
-			cfg.maxOutItems,
-			feed_types.HttpSourceFeedItemToOutFeedItem,
-		)
+		outFeed.Items = reloadOutFeedItems(outFeedData, cfg, extras)
 
 		if outFeedData.UpdatedParsed != nil && len(outFeed.Items) == len(outFeedData.Items) {
 			outFeed.Updated = *outFeedData.UpdatedParsed
@@ -271,9 +354,12 @@ func startSourceFeedsReceiver(
 		outFeed.Updated = time.Now()
 	}
 
-	newOutXml := feedToStr(outFeed)
+	legacyIds := newLegacyItemIds(outFeed.Items, util.StateKey("legacyids", cfg.outFeedFilename))
+	cfg.dedup.restoreLegacySourceKeys(legacyIds)
+
+	newOutXml := feedToXml(outFeed, extras, cfg)
 
-	if newOutXml == "" {
+	if newOutXml.atom == "" {
 		util.LogWarn("Can't generate out XML.")
 		sourceFeedsReceiverStopped <- true
 		return
@@ -282,7 +368,9 @@ func startSourceFeedsReceiver(
 	outXmlChan <- newOutXml
 
 	if changed {
-		saveToFile(cfg.outFeedFilename, newOutXml)
+		saveOutFeed(cfg, newOutXml)
+	} else if _, err := os.Stat(cfg.outRssFilename); cfg.outRssFilename != "" && err != nil {
+		saveOutRss(cfg, newOutXml)
 	}
 
 	for {
@@ -296,14 +384,28 @@ func startSourceFeedsReceiver(
 			oldIds = append(oldIds, item.Id)
 		}
 
+		newItems, nDropped := filterItems(chanItem.feed.Items, cfg.filter, chanItem.source.filter)
+		if nDropped > 0 {
+			util.LogInfo(fmt.Sprintf("%s: %d item(s) dropped by filters", chanItem.source.url, nDropped))
+		}
+
 		outFeed.Items = mergeOutFeedItems(
 			outFeed.Items,
-			chanItem.feed.Items,
+			newItems,
 			cfg.maxOutItems,
-			chanItem.source.funcs.SourceFeedItemToOutFeedItem,
+			sourceItemConverter(chanItem.source, &chanItem.feed, cfg, legacyIds),
+			cfg.dedup,
+			cfg.updates,
+			extras,
+			chanItem.source.itemSource(&chanItem.feed),
+			cfg.updates.isResurfacing(),
 		)
+		legacyIds.prune(outFeed.Items)
+		legacyIds.save()
 
-		changed = false
+		// the merged duplicates and the updated items change the existing items
+		changed = cfg.dedup != nil && cfg.dedup.takeChanged()
+		changed = cfg.updates != nil && cfg.updates.takeChanged() || changed
 		for i, item := range outFeed.Items {
 			if len(oldIds) <= i {
 				changed = true
@@ -315,19 +417,22 @@ func startSourceFeedsReceiver(
 			}
 		}
 		if !changed {
+			chanItem.saveState()
 			continue
 		}
 
 		outFeed.Updated = time.Now()
 
-		newOutXml = feedToStr(outFeed)
-		if newOutXml == "" {
+		newOutXml = feedToXml(outFeed, extras, cfg)
+		if newOutXml.atom == "" {
 			continue
 		}
 
 		outXmlChan <- newOutXml
 
-		saveToFile(cfg.outFeedFilename, newOutXml)
+		if saveOutFeed(cfg, newOutXml) {
+			chanItem.saveState()
+		}
 	}
 
 	sourceFeedsReceiverStopped <- true
@@ -351,7 +456,7 @@ func loadOutFeed(cfg Config) *gofeed.Feed {
 		return nil
 	}
 
-	fp := gofeed.NewParser()
+	fp := feed_types.NewFeedParser()
 	feed, err := fp.Parse(file)
 	if err != nil {
 		util.LogWarn(err)
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var filterFields = []string{"title", "content", "author", "link", "categories"}
+var defaultFilterFields = []string{"title", "content"}
+
+type filterRule struct {
+	keyword       string
+	regex         *regexp.Regexp
+	fields        []string
+	caseSensitive bool
+	until         time.Time
+}
+
+// An item passes the filter if it matches any of the include rules (or there are none)
+// and doesn't match any of the exclude rules.
+type itemFilter struct {
+	include []*filterRule
+	exclude []*filterRule
+}
+
+func newFilterRule(rawRule interface{}) *filterRule {
+	options := viper.New()
+	switch rule := rawRule.(type) {
+	case string:
+		options.Set("keyword", rule)
+
+	case map[string]interface{}:
+		err := options.MergeConfigMap(rule)
+		if err != nil {
+			panic(err)
+		}
+
+	default:
+		panic(fmt.Sprintf("Invalid filter rule: %v", rawRule))
+	}
+
+	rule := &filterRule{
+		keyword:       options.GetString("keyword"),
+		fields:        getStringSlice(options, "fields", defaultFilterFields),
+		caseSensitive: options.GetBool("caseSensitive"),
+	}
+
+	regexStr := options.GetString("regex")
+	if (rule.keyword == "") == (regexStr == "") {
+		panic(fmt.Sprintf("Filter rule must have either \"keyword\" or \"regex\": %v", rawRule))
+	}
+	if regexStr != "" {
+		if !rule.caseSensitive {
+			regexStr = "(?i)" + regexStr
+		}
+		rx, err := regexp.Compile(regexStr)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid filter regex: %v: %s", rawRule, err))
+		}
+		rule.regex = rx
+	}
+	if !rule.caseSensitive {
+		rule.keyword = strings.ToLower(rule.keyword)
+	}
+
+	fields := make([]string, len(rule.fields))
+	for i, field := range rule.fields {
+		fields[i] = strings.ToLower(field)
+		isKnown := false
+		for _, knownField := range filterFields {
+			isKnown = isKnown || fields[i] == knownField
+		}
+		if !isKnown {
+			panic(fmt.Sprintf("Unknown filter field \"%s\", use one of: %s", field, strings.Join(filterFields, ", ")))
+		}
+	}
+	rule.fields = fields
+
+	if options.IsSet("until") {
+		rule.until = options.GetTime("until")
+		if rule.until.IsZero() {
+			panic(fmt.Sprintf("Invalid filter expiry date: %v", rawRule))
+		}
+		// a date without time means the end of that day
+		if rule.until.Hour() == 0 && rule.until.Minute() == 0 && rule.until.Second() == 0 {
+			rule.until = rule.until.Add(24 * time.Hour)
+		}
+	}
+
+	return rule
+}
+
+func getFilterRules(v *viper.Viper, key string) []*filterRule {
+	var rules []*filterRule
+	rawRules, ok := v.Get(key).([]interface{})
+	if !ok {
+		if v.IsSet(key) {
+			panic(fmt.Sprintf("\"%s\" must be an array", key))
+		}
+		return rules
+	}
+
+	for _, rawRule := range rawRules {
+		rules = append(rules, newFilterRule(rawRule))
+	}
+	return rules
+}
+
+// The filter is a map with the "include" and "exclude" arrays.
+func getFilter(v *viper.Viper, key string) *itemFilter {
+	rawFilter, ok := v.Get(key).(map[string]interface{})
+	if !ok {
+		if v.IsSet(key) {
+			panic(fmt.Sprintf("\"%s\" must be a map", key))
+		}
+		return nil
+	}
+
+	options := viper.New()
+	err := options.MergeConfigMap(rawFilter)
+	if err != nil {
+		panic(err)
+	}
+
+	return &itemFilter{
+		include: getFilterRules(options, "include"),
+		exclude: getFilterRules(options, "exclude"),
+	}
+}
+
+func itemFieldValues(item *gofeed.Item, field string) []string {
+	switch field {
+	case "title":
+		return []string{item.Title}
+
+	case "content":
+		return []string{item.Content, item.Description}
+
+	case "author":
+		var values []string
+		if item.Author != nil {
+			values = append(values, item.Author.Name, item.Author.Email)
+		}
+		for _, author := range item.Authors {
+			values = append(values, author.Name, author.Email)
+		}
+		return values
+
+	case "link":
+		return append([]string{item.Link}, item.Links...)
+
+	case "categories":
+		return item.Categories
+
+	default:
+		return nil
+	}
+}
+
+func (rule *filterRule) matches(item *gofeed.Item, now time.Time) bool {
+	if !rule.until.IsZero() && now.After(rule.until) {
+		return false
+	}
+
+	for _, field := range rule.fields {
+		for _, value := range itemFieldValues(item, field) {
+			if value == "" {
+				continue
+			}
+			if rule.regex != nil {
+				if rule.regex.MatchString(value) {
+					return true
+				}
+				continue
+			}
+			if !rule.caseSensitive {
+				value = strings.ToLower(value)
+			}
+			if strings.Contains(value, rule.keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (filter *itemFilter) accepts(item *gofeed.Item, now time.Time) bool {
+	for _, rule := range filter.exclude {
+		if rule.matches(item, now) {
+			return false
+		}
+	}
+
+	hasIncludeRules := false
+	for _, rule := range filter.include {
+		if !rule.until.IsZero() && now.After(rule.until) {
+			continue
+		}
+		hasIncludeRules = true
+		if rule.matches(item, now) {
+			return true
+		}
+	}
+	return !hasIncludeRules
+}
+
+// Returns the items that pass all filters and the number of dropped items.
+func filterItems(items []*gofeed.Item, filters ...*itemFilter) ([]*gofeed.Item, int) {
+	now := time.Now()
+	var result []*gofeed.Item
+	for _, item := range items {
+		isAccepted := true
+		for _, filter := range filters {
+			if filter != nil && !filter.accepts(item, now) {
+				isAccepted = false
+				break
+			}
+		}
+		if isAccepted {
+			result = append(result, item)
+		}
+	}
+	return result, len(items) - len(result)
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"github.com/mmcdole/gofeed"
+	"testing"
+	"time"
+)
+
+func TestFilterRuleMatches(t *testing.T) {
+	item := &gofeed.Item{
+		Title:      "Go 1.22 Released",
+		Content:    "<p>Range over integers</p>",
+		Link:       "https://go.dev/blog/go1.22",
+		Authors:    []*gofeed.Person{{Name: "Gopher"}},
+		Categories: []string{"release"},
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rule     interface{}
+		expected bool
+	}{
+		{"keyword in title", "released", true},
+		{"keyword in content", "integers", true},
+		{"no match", "rust", false},
+		{"case sensitive", map[string]interface{}{"keyword": "released", "caseSensitive": true}, false},
+		{"regex", map[string]interface{}{"regex": `go\s*1\.\d+`}, true},
+		{"other field", map[string]interface{}{"keyword": "gopher", "fields": []interface{}{"author"}}, true},
+		{"field not checked", map[string]interface{}{"keyword": "go.dev"}, false},
+		{"link", map[string]interface{}{"keyword": "go.dev", "fields": []interface{}{"link"}}, true},
+		{"categories", map[string]interface{}{"keyword": "release", "fields": []interface{}{"categories"}}, true},
+		{"until later", map[string]interface{}{"keyword": "released", "until": "2024-06-01"}, true},
+		{"until the same day", map[string]interface{}{"keyword": "released", "until": "2024-05-01"}, true},
+		{"until expired", map[string]interface{}{"keyword": "released", "until": "2024-04-30"}, false},
+		{"until expired with time", map[string]interface{}{"keyword": "released", "until": "2024-05-01T11:00:00Z"}, false},
+	}
+
+	for _, test := range tests {
+		if newFilterRule(test.rule).matches(item, now) != test.expected {
+			t.Errorf("%s: expected %v", test.name, test.expected)
+		}
+	}
+}
+
+func TestFilterAccepts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expired := map[string]interface{}{"keyword": "sale", "until": "2024-04-01"}
+
+	tests := []struct {
+		name     string
+		filter   itemFilter
+		title    string
+		expected bool
+	}{
+		{"no rules", itemFilter{}, "anything", true},
+		{"excluded", itemFilter{exclude: []*filterRule{newFilterRule("sale")}}, "Big sale", false},
+		{"not excluded", itemFilter{exclude: []*filterRule{newFilterRule("sale")}}, "News", true},
+		{"included", itemFilter{include: []*filterRule{newFilterRule("go")}}, "Go news", true},
+		{"not included", itemFilter{include: []*filterRule{newFilterRule("go")}}, "Rust news", false},
+		{"exclude wins", itemFilter{
+			include: []*filterRule{newFilterRule("go")},
+			exclude: []*filterRule{newFilterRule("sale")},
+		}, "Go sale", false},
+		{"expired exclude", itemFilter{exclude: []*filterRule{newFilterRule(expired)}}, "Big sale", true},
+		// an expired include rule doesn't count, so it doesn't drop everything else
+		{"only expired include", itemFilter{include: []*filterRule{newFilterRule(expired)}}, "News", true},
+	}
+
+	for _, test := range tests {
+		if test.filter.accepts(&gofeed.Item{Title: test.title}, now) != test.expected {
+			t.Errorf("%s: expected %v", test.name, test.expected)
+		}
+	}
+}
+
+func TestFilterItems(t *testing.T) {
+	items := []*gofeed.Item{{Title: "One"}, {Title: "Two"}, {Title: "Three"}}
+	global := &itemFilter{exclude: []*filterRule{newFilterRule("one")}}
+	source := &itemFilter{exclude: []*filterRule{newFilterRule("two")}}
+
+	result, nDropped := filterItems(items, global, nil, source)
+	if nDropped != 2 || len(result) != 1 || result[0].Title != "Three" {
+		t.Errorf("unexpected result: %d dropped, %v", nDropped, result)
+	}
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"feedmash/util"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var youtubeChannelIdRx = regexp.MustCompile(`^UC[0-9A-Za-z_-]{22}$`)
+
+type youtubeSubscription struct {
+	channelId string
+	title     string
+}
+
+func youtubeChannelUrl(channelId string) string {
+	return "https://www.youtube.com/channel/" + channelId
+}
+
+// Extracts the channel ID from the source URL if it's a YouTube URL that contains it.
+// Returns an empty string for other URLs (including @handle URLs).
+func youtubeChannelIdFromSource(source string) string {
+	urlObj, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+
+	channelId := urlObj.Query().Get("channel_id")
+	if channelId != "" {
+		return channelId
+	}
+
+	parts := strings.Split(strings.Trim(urlObj.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "channel" {
+		return parts[1]
+	}
+
+	return ""
+}
+
+func readYoutubeSubscriptions(csvFilename string) ([]youtubeSubscription, error) {
+	file, err := os.Open(csvFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			util.LogWarn(err)
+		}
+	}()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	var subs []youtubeSubscription
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The first row is a header with localized column names,
+		// so just skip every row that doesn't start with a channel ID.
+		channelId := strings.TrimSpace(record[0])
+		if !youtubeChannelIdRx.MatchString(channelId) {
+			continue
+		}
+
+		title := ""
+		if len(record) >= 3 {
+			title = strings.TrimSpace(record[2])
+		}
+
+		subs = append(subs, youtubeSubscription{
+			channelId: channelId,
+			title:     title,
+		})
+	}
+
+	return subs, nil
+}
+
+func findMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func importYoutubeSubscriptions(cfgFilename string, csvFilename string) error {
+	subs, err := readYoutubeSubscriptions(csvFilename)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return fmt.Errorf("no YouTube channels found in %s", csvFilename)
+	}
+
+	cfgData, err := os.ReadFile(cfgFilename)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(cfgData, &doc)
+	if err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("the config file must contain a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	sourcesNode := findMappingValue(root, "sources")
+	if sourcesNode == nil {
+		sourcesNode = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "sources"}, sourcesNode)
+	}
+	if sourcesNode.Kind != yaml.SequenceNode {
+		return errors.New("\"sources\" must be an array")
+	}
+
+	existingIds := map[string]bool{}
+	for _, sourceNode := range sourcesNode.Content {
+		source := sourceNode.Value
+		if sourceNode.Kind == yaml.MappingNode {
+			urlNode := findMappingValue(sourceNode, "url")
+			if urlNode == nil {
+				continue
+			}
+			source = urlNode.Value
+		}
+		channelId := youtubeChannelIdFromSource(source)
+		if channelId != "" {
+			existingIds[channelId] = true
+		}
+	}
+
+	nAdded := 0
+	for _, sub := range subs {
+		if existingIds[sub.channelId] {
+			continue
+		}
+		existingIds[sub.channelId] = true
+
+		sourcesNode.Content = append(sourcesNode.Content, &yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Tag:         "!!str",
+			Value:       youtubeChannelUrl(sub.channelId),
+			LineComment: sub.title,
+		})
+		nAdded++
+	}
+
+	util.LogInfo(fmt.Sprintf("Channels found: %d, added: %d, skipped as duplicates: %d", len(subs), nAdded, len(subs)-nAdded))
+	if nAdded == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&doc)
+	if err != nil {
+		return err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	return util.SaveToFile(cfgFilename, buf.String())
+}
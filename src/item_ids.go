@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"feedmash/util"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"strings"
+)
+
+// The IDs of the output items are prefixed with the key of their source,
+// so the sources that use simple GUIDs like "1", "2" don't swallow each other's items.
+const scopedItemIdPrefix = "tag:feedmash.local,2021:"
+
+// The key only depends on the source URL, so the IDs survive restarts and config reordering.
+func sourceKey(sourceUrl string) string {
+	hash := sha1.Sum([]byte(sourceUrl))
+	return hex.EncodeToString(hash[:6])
+}
+
+func scopedItemId(sourceUrl string, id string) string {
+	return scopedItemIdPrefix + sourceKey(sourceUrl) + ":" + id
+}
+
+// The key of the item's source: from the source URL, or from the ID of a saved item.
+// Empty if the source is unknown, e.g. for the items that were saved before the IDs became source-scoped
+// (see legacyItemIds for those).
+func itemSourceKey(sourceUrl string, itemId string) string {
+	if sourceUrl != "" {
+		return sourceKey(sourceUrl)
+	}
+	if !strings.HasPrefix(itemId, scopedItemIdPrefix) {
+		return ""
+	}
+	key, _, isFound := strings.Cut(strings.TrimPrefix(itemId, scopedItemIdPrefix), ":")
+	if !isFound {
+		return ""
+	}
+	return key
+}
+
+func itemLink(item *feeds.Item) string {
+	if item.Link == nil {
+		return ""
+	}
+	return item.Link.Href
+}
+
+type legacyItem struct {
+	Link      string `json:"link"`
+	SourceKey string `json:"sourceKey,omitempty"`
+}
+
+// The IDs (and links) of the items that were saved before the IDs became source-scoped.
+// These items keep their IDs, so feed readers don't show them as new ones.
+// The source of such item is learned when the source reports the item again (the link tells it's the same item),
+// and it's kept in the state, so these items take part in the deduplication like the other ones.
+type legacyItemIds struct {
+	stateKey string
+	items    map[string]*legacyItem
+	changed  bool
+}
+
+// The state is not used if stateKey is empty.
+func newLegacyItemIds(items []*feeds.Item, stateKey string) *legacyItemIds {
+	savedItems := map[string]*legacyItem{}
+	if stateKey != "" {
+		util.LoadState(stateKey, &savedItems)
+	}
+
+	ids := &legacyItemIds{stateKey: stateKey, items: map[string]*legacyItem{}}
+	for _, item := range items {
+		if strings.HasPrefix(item.Id, scopedItemIdPrefix) {
+			continue
+		}
+		ids.items[item.Id] = &legacyItem{Link: itemLink(item)}
+		if savedItem := savedItems[item.Id]; savedItem != nil && savedItem.Link == itemLink(item) {
+			ids.items[item.Id].SourceKey = savedItem.SourceKey
+		}
+	}
+	ids.changed = len(ids.items) != len(savedItems)
+	return ids
+}
+
+func (ids *legacyItemIds) has(item *feeds.Item) bool {
+	if ids == nil {
+		return false
+	}
+	legacy := ids.items[item.Id]
+	return legacy != nil && legacy.Link == itemLink(item)
+}
+
+// Remembers the source that has reported the item.
+func (ids *legacyItemIds) claim(item *feeds.Item, sourceUrl string) {
+	legacy := ids.items[item.Id]
+	key := sourceKey(sourceUrl)
+	if legacy.SourceKey != key {
+		legacy.SourceKey = key
+		ids.changed = true
+	}
+}
+
+// Empty if the source of the item is not known yet.
+func (ids *legacyItemIds) sourceKey(itemId string) string {
+	if ids == nil || ids.items[itemId] == nil {
+		return ""
+	}
+	return ids.items[itemId].SourceKey
+}
+
+// Forgets the items that are no longer in the output feed.
+func (ids *legacyItemIds) prune(items []*feeds.Item) {
+	keptIds := map[string]bool{}
+	for _, item := range items {
+		keptIds[item.Id] = true
+	}
+	for id := range ids.items {
+		if !keptIds[id] {
+			delete(ids.items, id)
+			ids.changed = true
+		}
+	}
+}
+
+func (ids *legacyItemIds) save() {
+	if !ids.changed || ids.stateKey == "" {
+		return
+	}
+	util.SaveState(ids.stateKey, ids.items)
+	ids.changed = false
+}
+
+// Wraps the item conversion function of a source to make the item IDs source-scoped.
+func withScopedIds(
+	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	sourceUrl string,
+	legacyIds *legacyItemIds,
+) func(item *gofeed.Item) *feeds.Item {
+	return func(item *gofeed.Item) *feeds.Item {
+		outItem := sourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			return nil
+		}
+		if legacyIds.has(outItem) {
+			legacyIds.claim(outItem, sourceUrl)
+			return outItem
+		}
+		outItem.Id = scopedItemId(sourceUrl, outItem.Id)
+		return outItem
+	}
+}
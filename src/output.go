@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/feed_types"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"strings"
+)
+
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+const mediaNamespace = "http://search.yahoo.com/mrss/"
+
+type outEnclosure struct {
+	url      string
+	length   string
+	mimeType string
+}
+
+type outPerson struct {
+	name  string
+	email string
+}
+
+// The data of the output item that feeds.Item doesn't have.
+type itemExtra struct {
+	enclosures   []outEnclosure
+	image        string
+	duration     string
+	thumbnail    string
+	categories   []string
+	authors      []outPerson
+	contributors []outPerson
+	origin       *feed_types.ItemOrigin
+}
+
+// The extra data of the output items, by their ID.
+type itemExtras map[string]*itemExtra
+
+// The output feed in all formats.
+type outFeedXml struct {
+	atom string
+	rss  string
+}
+
+func itunesValue(item *gofeed.Item, name string) string {
+	for _, ext := range item.Extensions["itunes"][name] {
+		if value := strings.TrimSpace(ext.Value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func outPersons(persons []*gofeed.Person) []outPerson {
+	var result []outPerson
+	for _, person := range persons {
+		if person == nil || (person.Name == "" && person.Email == "") {
+			continue
+		}
+		result = append(result, outPerson{name: person.Name, email: person.Email})
+	}
+	return result
+}
+
+// The categories of the item and the name of its source, without duplicates.
+func itemCategories(item *gofeed.Item, sourceName string) []string {
+	var categories []string
+	isAdded := map[string]bool{}
+	for _, category := range append(item.Categories, sourceName) {
+		category = strings.TrimSpace(category)
+		if category == "" || isAdded[strings.ToLower(category)] {
+			continue
+		}
+		isAdded[strings.ToLower(category)] = true
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// The source is empty for the items that are loaded from the output file;
+// they already have the category with their source name and the <source> element.
+func newItemExtra(item *gofeed.Item, source itemSource) *itemExtra {
+	extra := &itemExtra{
+		thumbnail:    feed_types.ItemThumbnail(item),
+		categories:   itemCategories(item, source.name),
+		authors:      outPersons(item.Authors),
+		contributors: outPersons(feed_types.ItemContributors(item)),
+		origin:       feed_types.ItemOriginOf(item, source.feed, source.url),
+	}
+	if extra.origin != nil && extra.origin.Title == "" {
+		extra.origin.Title = source.name
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure.URL == "" {
+			continue
+		}
+		extra.enclosures = append(extra.enclosures, outEnclosure{
+			url:      enclosure.URL,
+			length:   enclosure.Length,
+			mimeType: enclosure.Type,
+		})
+	}
+
+	if item.ITunesExt != nil {
+		extra.image = item.ITunesExt.Image
+		extra.duration = item.ITunesExt.Duration
+	}
+	if extra.image == "" {
+		// Atom feeds, including the saved output feed
+		for _, ext := range item.Extensions["itunes"]["image"] {
+			if ext.Attrs["href"] != "" {
+				extra.image = ext.Attrs["href"]
+				break
+			}
+		}
+	}
+	if extra.image == "" && item.Image != nil {
+		extra.image = item.Image.URL
+	}
+	if extra.duration == "" {
+		extra.duration = itunesValue(item, "duration")
+	}
+
+	return extra
+}
+
+func (extras itemExtras) set(id string, item *gofeed.Item, source itemSource) {
+	if extras != nil {
+		extras[id] = newItemExtra(item, source)
+	}
+}
+
+// Never returns nil.
+func (extras itemExtras) get(item *feeds.Item) *itemExtra {
+	extra := &itemExtra{}
+	if storedExtra := extras[item.Id]; storedExtra != nil {
+		*extra = *storedExtra
+	}
+	if len(extra.enclosures) == 0 && item.Enclosure != nil && item.Enclosure.Url != "" {
+		// the item conversion functions of some sources only set the enclosure of feeds.Item
+		extra.enclosures = []outEnclosure{{
+			url:      item.Enclosure.Url,
+			length:   item.Enclosure.Length,
+			mimeType: item.Enclosure.Type,
+		}}
+	}
+	if len(extra.authors) == 0 && item.Author != nil && (item.Author.Name != "" || item.Author.Email != "") {
+		extra.authors = []outPerson{{name: item.Author.Name, email: item.Author.Email}}
+	}
+	return extra
+}
+
+// Forgets the items that are no longer in the output feed.
+func (extras itemExtras) prune(items []*feeds.Item) {
+	ids := map[string]bool{}
+	for _, item := range items {
+		ids[item.Id] = true
+	}
+	for id := range extras {
+		if !ids[id] {
+			delete(extras, id)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"encoding/xml"
+	"feedmash/feed_types"
+	"feedmash/util"
+	"github.com/gorilla/feeds"
+	"strings"
+	"time"
+)
+
+// The Atom structures are similar to the ones in gorilla/feeds,
+// but with the elements that gorilla/feeds doesn't support.
+
+type atomText struct {
+	Content string `xml:",chardata"`
+	Type    string `xml:"type,attr"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name,omitempty"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomSource struct {
+	Id      string     `xml:"id,omitempty"`
+	Title   string     `xml:"title,omitempty"`
+	Updated string     `xml:"updated,omitempty"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type mediaThumbnail struct {
+	Url string `xml:"url,attr"`
+}
+
+type atomEntry struct {
+	XMLName      xml.Name        `xml:"entry"`
+	Title        string          `xml:"title"`
+	Updated      string          `xml:"updated"`
+	Id           string          `xml:"id"`
+	Content      *atomText       `xml:"content"`
+	Published    string          `xml:"published,omitempty"`
+	Links        []atomLink      `xml:"link"`
+	Summary      *atomText       `xml:"summary"`
+	Authors      []atomPerson    `xml:"author"`
+	Contributors []atomPerson    `xml:"contributor"`
+	Categories   []atomCategory  `xml:"category"`
+	Source       *atomSource     `xml:"source"`
+	Thumbnail    *mediaThumbnail `xml:"media:thumbnail"`
+	Duration     string          `xml:"itunes:duration,omitempty"`
+	Image        *itunesImage    `xml:"itunes:image"`
+}
+
+type atomFeed struct {
+	XMLName     xml.Name     `xml:"feed"`
+	Xmlns       string       `xml:"xmlns,attr"`
+	XmlnsItunes string       `xml:"xmlns:itunes,attr"`
+	XmlnsMedia  string       `xml:"xmlns:media,attr"`
+	Title       string       `xml:"title"`
+	Id          string       `xml:"id"`
+	Updated     string       `xml:"updated"`
+	Link        *atomLink    `xml:"link"`
+	Entries     []*atomEntry `xml:"entry"`
+}
+
+func newAtomSource(origin *feed_types.ItemOrigin) *atomSource {
+	source := &atomSource{
+		Id:    origin.Id,
+		Title: origin.Title,
+	}
+	if origin.Updated != nil {
+		source.Updated = origin.Updated.Format(time.RFC3339)
+	}
+	if origin.Link != "" {
+		source.Links = append(source.Links, atomLink{Href: origin.Link, Rel: "alternate"})
+	}
+	if origin.FeedLink != "" {
+		source.Links = append(source.Links, atomLink{Href: origin.FeedLink, Rel: "self"})
+	}
+	return source
+}
+
+func newAtomEntry(item *feeds.Item, extra *itemExtra) *atomEntry {
+	updated := item.Updated
+	if updated.IsZero() {
+		updated = item.Created
+	}
+
+	entry := &atomEntry{
+		Title:   item.Title,
+		Updated: updated.Format(time.RFC3339),
+		Id:      item.Id,
+		// <updated> is the date of the last change, so the original date must be kept separately
+		Published: item.Created.Format(time.RFC3339),
+		Duration:  extra.duration,
+	}
+
+	if link := itemLink(item); link != "" {
+		entry.Links = append(entry.Links, atomLink{Href: link, Rel: "alternate"})
+	}
+
+	if item.Description != "" {
+		entry.Summary = &atomText{Content: item.Description, Type: "html"}
+	}
+	if item.Content != "" {
+		entry.Content = &atomText{Content: item.Content, Type: "html"}
+	}
+	for _, author := range extra.authors {
+		entry.Authors = append(entry.Authors, atomPerson{Name: author.name, Email: author.email})
+	}
+	for _, contributor := range extra.contributors {
+		entry.Contributors = append(entry.Contributors, atomPerson{Name: contributor.name, Email: contributor.email})
+	}
+	for _, category := range extra.categories {
+		entry.Categories = append(entry.Categories, atomCategory{Term: category})
+	}
+	if extra.thumbnail != "" {
+		entry.Thumbnail = &mediaThumbnail{Url: extra.thumbnail}
+	}
+	if extra.origin != nil {
+		entry.Source = newAtomSource(extra.origin)
+	}
+
+	for _, enclosure := range extra.enclosures {
+		entry.Links = append(entry.Links, atomLink{
+			Href:   enclosure.url,
+			Rel:    "enclosure",
+			Type:   enclosure.mimeType,
+			Length: enclosure.length,
+		})
+	}
+	if extra.image != "" {
+		entry.Image = &itunesImage{Href: extra.image}
+	}
+
+	return entry
+}
+
+func feedToAtomStr(feed *feeds.Feed, extras itemExtras) string {
+	xmlFeed := &atomFeed{
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsItunes: itunesNamespace,
+		XmlnsMedia:  mediaNamespace,
+		Title:       feed.Title,
+		Id:          feed.Id,
+		Updated:     feed.Updated.Format(time.RFC3339),
+		Link:        &atomLink{Href: feed.Link.Href, Rel: feed.Link.Rel},
+	}
+	for _, item := range feed.Items {
+		xmlFeed.Entries = append(xmlFeed.Entries, newAtomEntry(item, extras.get(item)))
+	}
+
+	data, err := xml.Marshal(xmlFeed)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	xmlStr := strings.TrimSpace(xml.Header) + string(data)
+	return xmlStr
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"encoding/xml"
+	"feedmash/util"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// The iTunes tags that make the RSS feed a podcast feed.
+type podcastProfile struct {
+	author      string
+	image       string
+	category    string
+	explicit    bool
+	description string
+}
+
+// Returns nil if the podcast profile is disabled.
+func getPodcastProfile(v *viper.Viper, key string) *podcastProfile {
+	options := viper.New()
+	switch rawOptions := v.Get(key).(type) {
+	case nil:
+		return nil
+
+	case map[string]interface{}:
+		err := options.MergeConfigMap(rawOptions)
+		if err != nil {
+			panic(err)
+		}
+
+	default:
+		panic(fmt.Sprintf("\"%s\" must be a map", key))
+	}
+
+	options.SetDefault("enabled", true)
+	if !options.GetBool("enabled") {
+		return nil
+	}
+
+	return &podcastProfile{
+		author:      options.GetString("author"),
+		image:       options.GetString("image"),
+		category:    options.GetString("category"),
+		explicit:    options.GetBool("explicit"),
+		description: options.GetString("description"),
+	}
+}
+
+type rssGuid struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink string `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssSource struct {
+	Url   string `xml:"url,attr"`
+	Title string `xml:",chardata"`
+}
+
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type rssItem struct {
+	XMLName     xml.Name        `xml:"item"`
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link,omitempty"`
+	Guid        rssGuid         `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Author      string          `xml:"author,omitempty"`
+	Description string          `xml:"description,omitempty"`
+	Categories  []string        `xml:"category"`
+	Enclosure   *rssEnclosure   `xml:"enclosure"`
+	Thumbnail   *mediaThumbnail `xml:"media:thumbnail"`
+	Source      *rssSource      `xml:"source"`
+
+	ItunesAuthor   string       `xml:"itunes:author,omitempty"`
+	ItunesDuration string       `xml:"itunes:duration,omitempty"`
+	ItunesImage    *itunesImage `xml:"itunes:image"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+
+	ItunesAuthor   string          `xml:"itunes:author,omitempty"`
+	ItunesSummary  string          `xml:"itunes:summary,omitempty"`
+	ItunesImage    *itunesImage    `xml:"itunes:image"`
+	ItunesCategory *itunesCategory `xml:"itunes:category"`
+	ItunesExplicit string          `xml:"itunes:explicit,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName     xml.Name    `xml:"rss"`
+	Version     string      `xml:"version,attr"`
+	XmlnsItunes string      `xml:"xmlns:itunes,attr,omitempty"`
+	XmlnsMedia  string      `xml:"xmlns:media,attr"`
+	Channel     *rssChannel `xml:"channel"`
+}
+
+func newRssItem(item *feeds.Item, extra *itemExtra, podcast *podcastProfile) *rssItem {
+	xmlItem := &rssItem{
+		Title:       item.Title,
+		Link:        itemLink(item),
+		Guid:        rssGuid{Value: item.Id, IsPermaLink: "false"},
+		PubDate:     item.Created.Format(time.RFC1123Z),
+		Description: item.Content,
+	}
+	if xmlItem.Description == "" {
+		xmlItem.Description = item.Description
+	}
+	// RSS only allows one author per item, and it must have an email
+	for _, author := range extra.authors {
+		if author.email != "" {
+			xmlItem.Author = author.email
+			if author.name != "" {
+				xmlItem.Author += " (" + author.name + ")"
+			}
+			break
+		}
+	}
+	xmlItem.Categories = extra.categories
+	if extra.thumbnail != "" {
+		xmlItem.Thumbnail = &mediaThumbnail{Url: extra.thumbnail}
+	}
+	// the URL of the source feed is required in RSS
+	if extra.origin != nil && extra.origin.FeedLink != "" {
+		xmlItem.Source = &rssSource{Url: extra.origin.FeedLink, Title: extra.origin.Title}
+	}
+
+	// RSS only allows one enclosure per item
+	if len(extra.enclosures) > 0 {
+		enclosure := extra.enclosures[0]
+		length := enclosure.length
+		if length == "" {
+			length = "0"
+		}
+		xmlItem.Enclosure = &rssEnclosure{Url: enclosure.url, Length: length, Type: enclosure.mimeType}
+	}
+
+	if podcast != nil {
+		if len(extra.authors) > 0 {
+			xmlItem.ItunesAuthor = extra.authors[0].name
+		}
+		xmlItem.ItunesDuration = extra.duration
+		if extra.image != "" {
+			xmlItem.ItunesImage = &itunesImage{Href: extra.image}
+		}
+	}
+
+	return xmlItem
+}
+
+func feedToRssStr(feed *feeds.Feed, extras itemExtras, podcast *podcastProfile) string {
+	channel := &rssChannel{
+		Title:         feed.Title,
+		Link:          feed.Link.Href,
+		Description:   feed.Title,
+		LastBuildDate: feed.Updated.Format(time.RFC1123Z),
+	}
+	xmlFeed := &rssFeed{
+		Version:    "2.0",
+		XmlnsMedia: mediaNamespace,
+		Channel:    channel,
+	}
+
+	if podcast != nil {
+		xmlFeed.XmlnsItunes = itunesNamespace
+		if podcast.description != "" {
+			channel.Description = podcast.description
+		}
+		channel.ItunesAuthor = podcast.author
+		channel.ItunesSummary = channel.Description
+		if podcast.image != "" {
+			channel.ItunesImage = &itunesImage{Href: podcast.image}
+		}
+		if podcast.category != "" {
+			channel.ItunesCategory = &itunesCategory{Text: podcast.category}
+		}
+		channel.ItunesExplicit = "false"
+		if podcast.explicit {
+			channel.ItunesExplicit = "true"
+		}
+	}
+
+	for _, item := range feed.Items {
+		channel.Items = append(channel.Items, newRssItem(item, extras.get(item), podcast))
+	}
+
+	data, err := xml.Marshal(xmlFeed)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	xmlStr := strings.TrimSpace(xml.Header) + string(data)
+	return xmlStr
+}
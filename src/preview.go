@@ -0,0 +1,290 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"feedmash/feed_types"
+	"feedmash/util"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type previewResponse struct {
+	Url         string `json:"url"`
+	Status      string `json:"status"`
+	ContentType string `json:"contentType"`
+}
+
+// Status is "new", "saved" (already in the output feed) or "duplicate" (merged into another item).
+type previewItem struct {
+	Id     string    `json:"id"`
+	Date   time.Time `json:"date"`
+	Title  string    `json:"title"`
+	Link   string    `json:"link"`
+	Status string    `json:"status"`
+}
+
+type previewResult struct {
+	Url            string            `json:"url"`
+	RealUrl        string            `json:"realUrl"`
+	Type           string            `json:"type"`
+	Responses      []previewResponse `json:"responses"`
+	Format         string            `json:"format"`
+	ItemCount      int               `json:"itemCount"`
+	DroppedCount   int               `json:"droppedCount"`
+	FilteredCount  int               `json:"filteredCount"`
+	DuplicateCount int               `json:"duplicateCount"`
+	Items          []previewItem     `json:"items"`
+}
+
+// Remembers the status of every HTTP response, including redirects.
+type recordingTransport struct {
+	transport http.RoundTripper
+	responses []previewResponse
+	mutex     sync.Mutex
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	t.mutex.Lock()
+	t.responses = append(t.responses, previewResponse{
+		Url:         req.URL.String(),
+		Status:      resp.Status,
+		ContentType: contentType,
+	})
+	t.mutex.Unlock()
+	return resp, nil
+}
+
+// Some types remember what they've already seen,
+// so the preview works with a copy of the state to not affect the running instance.
+func copyStateDir(srcDir string) (string, error) {
+	dstDir, err := os.MkdirTemp("", appId+"-preview-")
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return dstDir, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(srcDir, entry.Name()))
+		if err != nil {
+			return dstDir, err
+		}
+		err = os.WriteFile(filepath.Join(dstDir, entry.Name()), data, 0644)
+		if err != nil {
+			return dstDir, err
+		}
+	}
+	return dstDir, nil
+}
+
+func previewSourceResult(cfgFilename string, sourceUrl string) (*previewResult, error) {
+	sourceCfg := sourceConfigForUrl(cfgFilename, sourceUrl)
+	cfg := Config{
+		userAgent: appTitle,
+		sanitizer: getSanitizer(viper.New(), "sanitize"),
+	}
+	if cfgFilename != "" {
+		cfg = configFromFile(cfgFilename)
+	}
+
+	stateDir, err := copyStateDir(util.StateDir())
+	if stateDir != "" {
+		defer func() {
+			if err := os.RemoveAll(stateDir); err != nil {
+				util.LogWarn(err)
+			}
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+	util.SetStateDir(stateDir)
+
+	transport := &recordingTransport{transport: http.DefaultTransport}
+	http.DefaultTransport = transport
+
+	source := newFeedSource(sourceCfg)
+	if source == nil {
+		return nil, errors.New("can't determine the source type")
+	}
+
+	result := &previewResult{
+		Url:       sourceCfg.url,
+		Type:      feed_types.TypeName(source.feedType),
+		Responses: []previewResponse{},
+		Items:     []previewItem{},
+	}
+
+	result.RealUrl = source.funcs.RealUrl(source.urlObj)
+	if result.RealUrl == "" {
+		return nil, fmt.Errorf("cannot get real url: %s", sourceCfg.url)
+	}
+
+	feed, err := source.funcs.LoadFeed(result.RealUrl, cfg.userAgent)
+	result.Responses = append(result.Responses, transport.responses...)
+	if err != nil {
+		return result, err
+	}
+	// nothing is written, so the state of the source stays as it was
+	feed_types.TakeFeedState(feed)
+	feed_types.ResolveFeedUrls(feed, result.RealUrl)
+	feed_types.FillMissingLinks(feed)
+	feed_types.FillMissingDates(feed, result.RealUrl)
+
+	if feed.FeedType != "" {
+		result.Format = strings.TrimSpace(feed.FeedType + " " + feed.FeedVersion)
+	} else if len(result.Responses) > 0 {
+		result.Format = result.Responses[len(result.Responses)-1].ContentType
+	}
+
+	items, nFiltered := filterItems(feed.Items, cfg.filter, source.filter)
+	result.FilteredCount = nFiltered
+
+	// the items are merged into the saved output feed the same way as in the running instance,
+	// so the duplicates of the saved items are found too
+	extras := itemExtras{}
+	oldItems := []*feeds.Item{}
+	if cfgFilename != "" {
+		outFeedData := loadOutFeed(cfg)
+		if outFeedData != nil {
+			oldItems = reloadOutFeedItems(outFeedData, cfg, extras)
+		}
+	}
+	isSaved := map[string]bool{}
+	for _, item := range oldItems {
+		isSaved[item.Id] = true
+	}
+
+	// the state is not changed by the preview
+	legacyIds := newLegacyItemIds(oldItems, "")
+	cfg.dedup.restoreLegacySourceKeys(legacyIds)
+
+	var outItems []*feeds.Item
+	convert := sourceItemConverter(*source, feed, cfg, legacyIds)
+	resultItems := mergeOutFeedItems(
+		oldItems,
+		items,
+		len(oldItems)+len(items),
+		func(item *gofeed.Item) *feeds.Item {
+			outItem := convert(item)
+			if outItem == nil {
+				result.DroppedCount++
+			} else {
+				outItems = append(outItems, outItem)
+			}
+			return outItem
+		},
+		cfg.dedup,
+		cfg.updates,
+		extras,
+		source.itemSource(feed),
+		cfg.updates.isResurfacing(),
+	)
+	isMerged := map[string]bool{}
+	for _, item := range resultItems {
+		isMerged[item.Id] = true
+	}
+
+	for _, outItem := range outItems {
+		status := "new"
+		if isSaved[outItem.Id] {
+			status = "saved"
+		} else if !isMerged[outItem.Id] {
+			status = "duplicate"
+			result.DuplicateCount++
+		}
+
+		result.Items = append(result.Items, previewItem{
+			Id:     outItem.Id,
+			Date:   outItem.Created,
+			Title:  outItem.Title,
+			Link:   itemLink(outItem),
+			Status: status,
+		})
+	}
+	result.ItemCount = len(result.Items)
+
+	return result, nil
+}
+
+func printPreviewTable(result *previewResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "URL:\t%s\n", result.Url)
+	_, _ = fmt.Fprintf(w, "Real URL:\t%s\n", result.RealUrl)
+	_, _ = fmt.Fprintf(w, "Type:\t%s\n", result.Type)
+	for _, resp := range result.Responses {
+		_, _ = fmt.Fprintf(w, "HTTP:\t%s (%s)\n", resp.Status, resp.Url)
+	}
+	_, _ = fmt.Fprintf(w, "Format:\t%s\n", result.Format)
+	_, _ = fmt.Fprintf(w, "Items:\t%d\n", result.ItemCount)
+	if result.DroppedCount > 0 {
+		_, _ = fmt.Fprintf(w, "Dropped:\t%d\n", result.DroppedCount)
+	}
+	if result.FilteredCount > 0 {
+		_, _ = fmt.Fprintf(w, "Filtered out:\t%d\n", result.FilteredCount)
+	}
+	if result.DuplicateCount > 0 {
+		_, _ = fmt.Fprintf(w, "Duplicates:\t%d\n", result.DuplicateCount)
+	}
+	_ = w.Flush()
+
+	if len(result.Items) == 0 {
+		return
+	}
+
+	util.LogInfo("")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tDATE\tSTATUS\tTITLE\tLINK")
+	for _, item := range result.Items {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Id, item.Date.Format(time.RFC3339), item.Status, item.Title, item.Link)
+	}
+	_ = w.Flush()
+}
+
+func previewSource(cfgFilename string, sourceUrl string, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown output format \"%s\", use \"table\" or \"json\"", format)
+	}
+
+	result, err := previewSourceResult(cfgFilename, sourceUrl)
+	if result == nil {
+		return err
+	}
+
+	if format == "json" {
+		data, jsonErr := json.MarshalIndent(result, "", "  ")
+		if jsonErr != nil {
+			return jsonErr
+		}
+		util.LogInfo(string(data))
+	} else {
+		printPreviewTable(result)
+	}
+
+	return err
+}
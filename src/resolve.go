@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"errors"
+	"feedmash/feed_types"
+	"feedmash/util"
+	"fmt"
+	"github.com/spf13/viper"
+	"net/url"
+)
+
+// Uses the settings of the source with the same URL if it's in the config file.
+// The config file is optional, without it only the built-in types are used.
+func sourceConfigForUrl(cfgFilename string, sourceUrl string) SourceConfig {
+	if cfgFilename != "" {
+		cfg := configFromFile(cfgFilename)
+		util.SetStateDir(cfg.stateDir)
+		feed_types.SetRewriteRules(cfg.rewriteRules)
+
+		for _, sourceCfg := range cfg.sources {
+			if sourceCfg.url == sourceUrl {
+				return sourceCfg
+			}
+		}
+	}
+
+	options := viper.New()
+	options.Set("url", sourceUrl)
+	return SourceConfig{
+		url:     sourceUrl,
+		options: options,
+	}
+}
+
+func resolveSource(cfgFilename string, sourceUrl string) error {
+	sourceCfg := sourceConfigForUrl(cfgFilename, sourceUrl)
+	urlObj, err := url.Parse(sourceCfg.url)
+	if err != nil {
+		return err
+	}
+
+	util.LogInfo("URL:      " + sourceCfg.url)
+
+	rule, rewrittenUrl := feed_types.FindRewriteRule(*urlObj)
+	if rule != nil {
+		util.LogInfo("Rule:     " + rule.Match.String())
+		util.LogInfo("Feed URL: " + rewrittenUrl.String())
+	} else {
+		util.LogInfo("Rule:     (none)")
+	}
+
+	feedType, funcs := feed_types.Detect(*urlObj, sourceCfg.options)
+	if feedType == feed_types.Unknown {
+		return errors.New("can't determine the source type")
+	}
+	util.LogInfo("Type:     " + feed_types.TypeName(feedType))
+
+	realUrl := funcs.RealUrl(*urlObj)
+	if realUrl == "" {
+		return fmt.Errorf("cannot get real url: %s", sourceCfg.url)
+	}
+	util.LogInfo("Real URL: " + realUrl)
+
+	return nil
+}
@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/util"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var defaultAllowedTags = []string{
+	"a", "abbr", "audio", "b", "blockquote", "br", "caption", "cite", "code",
+	"dd", "del", "details", "div", "dl", "dt", "em", "figcaption", "figure",
+	"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "iframe", "img", "ins", "kbd",
+	"li", "mark", "ol", "p", "pre", "q", "s", "small", "source", "span", "strong",
+	"sub", "summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "time",
+	"tr", "u", "ul", "video",
+}
+
+var defaultAllowedAttributes = []string{
+	"href", "src", "alt", "title", "width", "height", "colspan", "rowspan",
+	"datetime", "cite", "start", "target", "controls", "poster", "type",
+}
+
+var defaultIframeHosts = []string{
+	"www.youtube.com", "www.youtube-nocookie.com", "player.vimeo.com",
+}
+
+// These elements are removed with their content, other unknown elements are replaced with their content.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"object": true, "embed": true, "applet": true, "frame": true, "frameset": true,
+	"form": true, "input": true, "button": true, "select": true, "textarea": true,
+	"head": true, "title": true, "meta": true, "link": true, "base": true,
+	"svg": true, "math": true,
+}
+
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true, "poster": true,
+}
+
+var allowedUrlSchemes = map[string]bool{
+	"http": true, "https": true, "mailto": true, "gemini": true, "mid": true,
+}
+
+type htmlSanitizer struct {
+	allowedTags       map[string]bool
+	allowedAttributes map[string]bool
+	iframeHosts       map[string]bool
+}
+
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[strings.ToLower(strings.TrimSpace(value))] = true
+	}
+	return set
+}
+
+// Returns nil if the sanitizer is disabled.
+func getSanitizer(v *viper.Viper, key string) *htmlSanitizer {
+	options := viper.New()
+	rawOptions := v.Get(key)
+	switch rawOptions := rawOptions.(type) {
+	case nil:
+		break
+
+	case map[string]interface{}:
+		err := options.MergeConfigMap(rawOptions)
+		if err != nil {
+			panic(err)
+		}
+
+	default:
+		panic(fmt.Sprintf("\"%s\" must be a map", key))
+	}
+
+	options.SetDefault("enabled", true)
+	if !options.GetBool("enabled") {
+		return nil
+	}
+
+	return &htmlSanitizer{
+		allowedTags:       stringSet(getStringSlice(options, "allowedTags", defaultAllowedTags)),
+		allowedAttributes: stringSet(getStringSlice(options, "allowedAttributes", defaultAllowedAttributes)),
+		iframeHosts:       stringSet(getStringSlice(options, "iframeHosts", defaultIframeHosts)),
+	}
+}
+
+func getAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func isSafeUrl(tag string, key string, value string) bool {
+	value = strings.TrimSpace(value)
+	urlObj, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	if urlObj.Scheme == "" {
+		return true
+	}
+
+	scheme := strings.ToLower(urlObj.Scheme)
+	if scheme == "data" {
+		// inline images, e.g. in emails
+		return tag == "img" && key == "src" && strings.HasPrefix(strings.ToLower(urlObj.Opaque), "image/")
+	}
+	return allowedUrlSchemes[scheme]
+}
+
+// Tracking pixels are 1×1 (or 0×0) images.
+func isTrackingPixel(node *html.Node) bool {
+	isPixelSize := func(value string) bool {
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+		return err == nil && size <= 1
+	}
+
+	width := getAttr(node, "width")
+	height := getAttr(node, "height")
+	if width != "" && height != "" && isPixelSize(width) && isPixelSize(height) {
+		return true
+	}
+
+	nPixelSizes := 0
+	for _, declaration := range strings.Split(getAttr(node, "style"), ";") {
+		property, value, _ := strings.Cut(declaration, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		if (property == "width" || property == "height") && isPixelSize(value) {
+			nPixelSizes++
+		}
+	}
+	return nPixelSizes >= 2
+}
+
+func (sanitizer *htmlSanitizer) isAllowedIframe(node *html.Node) bool {
+	srcUrl, err := url.Parse(strings.TrimSpace(getAttr(node, "src")))
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(srcUrl.Scheme)
+	return (scheme == "https" || scheme == "http") && sanitizer.iframeHosts[strings.ToLower(srcUrl.Hostname())]
+}
+
+func (sanitizer *htmlSanitizer) sanitizeAttributes(node *html.Node) {
+	var attrs []html.Attribute
+	for _, attr := range node.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || strings.HasPrefix(key, "on") || !sanitizer.allowedAttributes[key] {
+			continue
+		}
+		if urlAttributes[key] && !isSafeUrl(node.Data, key, attr.Val) {
+			continue
+		}
+		if key == "rel" && node.Data == "a" {
+			continue
+		}
+		attrs = append(attrs, html.Attribute{Key: key, Val: attr.Val})
+	}
+
+	if node.Data == "a" {
+		attrs = append(attrs, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+	}
+
+	node.Attr = attrs
+}
+
+func (sanitizer *htmlSanitizer) sanitizeChildren(parent *html.Node) {
+	for node := parent.FirstChild; node != nil; {
+		next := node.NextSibling
+
+		switch node.Type {
+		case html.TextNode:
+			break
+
+		case html.ElementNode:
+			tag := strings.ToLower(node.Data)
+			switch {
+			case droppedTags[tag],
+				tag == "img" && isTrackingPixel(node),
+				tag == "iframe" && !sanitizer.isAllowedIframe(node):
+				parent.RemoveChild(node)
+
+			case !sanitizer.allowedTags[tag]:
+				sanitizer.sanitizeChildren(node)
+				for child := node.FirstChild; child != nil; child = node.FirstChild {
+					node.RemoveChild(child)
+					parent.InsertBefore(child, node)
+				}
+				parent.RemoveChild(node)
+
+			default:
+				sanitizer.sanitizeAttributes(node)
+				sanitizer.sanitizeChildren(node)
+			}
+
+		default:
+			parent.RemoveChild(node)
+		}
+
+		node = next
+	}
+}
+
+func (sanitizer *htmlSanitizer) sanitize(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, node := range nodes {
+		root.AppendChild(node)
+	}
+	sanitizer.sanitizeChildren(root)
+
+	var sb strings.Builder
+	for node := root.FirstChild; node != nil; node = node.NextSibling {
+		err = html.Render(&sb, node)
+		if err != nil {
+			util.LogWarn(err)
+			return ""
+		}
+	}
+	return sb.String()
+}
+
+// Wraps the item conversion function to also sanitize the content.
+func withSanitizer(
+	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	sanitizer *htmlSanitizer,
+) func(item *gofeed.Item) *feeds.Item {
+	if sanitizer == nil {
+		return sourceFeedItemToOutFeedItem
+	}
+	return func(item *gofeed.Item) *feeds.Item {
+		outItem := sourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			return nil
+		}
+		outItem.Content = sanitizer.sanitize(outItem.Content)
+		outItem.Description = sanitizer.sanitize(outItem.Description)
+		return outItem
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	sanitizer := getSanitizer(viper.New(), "sanitizer")
+
+	tests := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{"allowed", `<p>Hello <b>world</b></p>`, `<p>Hello <b>world</b></p>`},
+		{"script", `<p>a</p><script>alert(1)</script>`, `<p>a</p>`},
+		{"style", `<style>p{}</style><p>a</p>`, `<p>a</p>`},
+		{"form", `<form><input name="x"/>text</form><p>a</p>`, `<p>a</p>`},
+		{"comment", `<p>a<!-- hidden --></p>`, `<p>a</p>`},
+		{"unknown tag", `<custom><i>a</i></custom>`, `<i>a</i>`},
+		{"event handler", `<p onclick="x()">a</p>`, `<p>a</p>`},
+		{"not allowed attribute", `<p class="x" style="color: red">a</p>`, `<p>a</p>`},
+		{"link", `<a href="https://example.com/" rel="opener">a</a>`, `<a href="https://example.com/" rel="noopener noreferrer">a</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">a</a>`, `<a rel="noopener noreferrer">a</a>`},
+		{"relative link", `<a href="/post">a</a>`, `<a href="/post" rel="noopener noreferrer">a</a>`},
+		{"gemini link", `<a href="gemini://example.com/">a</a>`, `<a href="gemini://example.com/" rel="noopener noreferrer">a</a>`},
+		{"data image", `<img src="data:image/png;base64,AAAA"/>`, `<img src="data:image/png;base64,AAAA"/>`},
+		{"data link", `<a href="data:text/html,x">a</a>`, `<a rel="noopener noreferrer">a</a>`},
+		{"tracking pixel", `<p>a<img src="https://t.example.com/p.gif" width="1" height="1"/></p>`, `<p>a</p>`},
+		{"tracking pixel in px", `<img src="https://t.example.com/p.gif" width="0px" height="0px"/>`, ``},
+		{"tracking pixel by style", `<img src="https://t.example.com/p.gif" style="width: 1px; height: 1px"/>`, ``},
+		{"small image", `<img src="https://example.com/i.png" width="16" height="1"/>`, `<img src="https://example.com/i.png" width="16" height="1"/>`},
+		{"allowed iframe", `<iframe src="https://www.youtube.com/embed/x"></iframe>`, `<iframe src="https://www.youtube.com/embed/x"></iframe>`},
+		{"other iframe", `<iframe src="https://example.com/"></iframe>`, ``},
+		{"empty", `  `, `  `},
+	}
+
+	for _, test := range tests {
+		got := sanitizer.sanitize(test.html)
+		if got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestSanitizerOptions(t *testing.T) {
+	v := viper.New()
+	v.Set("sanitizer", map[string]interface{}{"enabled": false})
+	if getSanitizer(v, "sanitizer") != nil {
+		t.Error("the sanitizer must be disabled")
+	}
+
+	v.Set("sanitizer", map[string]interface{}{
+		"allowedTags":       []interface{}{"p", "span"},
+		"allowedAttributes": []interface{}{"class"},
+	})
+	sanitizer := getSanitizer(v, "sanitizer")
+	got := sanitizer.sanitize(`<p class="x"><b>a</b><span title="t">b</span></p>`)
+	expected := `<p class="x">a<span>b</span></p>`
+	if got != expected {
+		t.Errorf("got %s, expected %s", got, expected)
+	}
+}
@@ -11,22 +11,30 @@ import (
 	"time"
 )
 
-func serverHandler(w http.ResponseWriter, outXml string) {
-	if outXml == "" {
+// The RSS version is served at /rss.xml, and the Atom version at all other paths.
+func serverHandler(w http.ResponseWriter, r *http.Request, outXml outFeedXml) {
+	body := outXml.atom
+	contentType := "application/atom+xml"
+	if r.URL.Path == "/rss.xml" {
+		body = outXml.rss
+		contentType = "application/rss+xml"
+	}
+
+	if body == "" {
 		w.WriteHeader(500)
 		return
 	}
-	w.Header().Add("Content-Type", "application/atom+xml")
-	w.Header().Add("Content-Length", strconv.Itoa(len(outXml)))
+	w.Header().Add("Content-Type", contentType)
+	w.Header().Add("Content-Length", strconv.Itoa(len(body)))
 
-	_, err := fmt.Fprint(w, outXml)
+	_, err := fmt.Fprint(w, body)
 	if err != nil {
 		util.LogWarn(err)
 	}
 }
 
-func runServer(addr string, stop chan bool, stopped chan bool, outXmlChan chan string) {
-	outXml := ""
+func runServer(addr string, stop chan bool, stopped chan bool, outXmlChan chan outFeedXml) {
+	outXml := outFeedXml{}
 
 	go func() {
 		for {
@@ -36,8 +44,8 @@ func runServer(addr string, stop chan bool, stopped chan bool, outXmlChan chan s
 
 	srv := &http.Server{
 		Addr: addr,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			serverHandler(w, outXml)
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serverHandler(w, r, outXml)
 		}),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"errors"
+	"feedmash/feed_types"
+	"feedmash/util"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const smtpMaxMessageSize = 25 * 1024 * 1024
+const smtpTimeout = 5 * time.Minute
+
+type smtpServer struct {
+	listener        net.Listener
+	mailboxes       map[string]FeedSource
+	sourceFeedsChan chan *FeedChanItem
+	handlers        sync.WaitGroup
+	conns           map[net.Conn]bool
+	connsMutex      sync.Mutex
+}
+
+// Each mailbox is a virtual source, e.g. mailto:newsletter-x@feedmash.local.
+func newMailboxSources(addresses []string) map[string]FeedSource {
+	mailboxes := map[string]FeedSource{}
+	for _, address := range addresses {
+		address = strings.ToLower(strings.TrimSpace(address))
+		if address == "" {
+			continue
+		}
+
+		sourceUrl := url.URL{Scheme: "mailto", Opaque: address}
+		mailboxes[address] = FeedSource{
+			url:      sourceUrl.String(),
+			urlObj:   sourceUrl,
+			feedType: feed_types.Email,
+			funcs:    &feed_types.EmailSourceFuncs,
+			realUrl:  sourceUrl.String(),
+		}
+	}
+	return mailboxes
+}
+
+func parseSmtpPath(arg string, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if path == "<>" {
+		return "", true
+	}
+	path = strings.TrimSpace(strings.SplitN(path, " ", 2)[0]) // drop ESMTP parameters
+	addr, err := mail.ParseAddress(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(addr.Address), true
+}
+
+func (srv *smtpServer) deliver(data []byte, recipients []string) {
+	item, err := feed_types.EmailToFeedItem(data)
+	if err != nil {
+		util.LogWarn(err)
+		return
+	}
+
+	for _, recipient := range recipients {
+		source := srv.mailboxes[recipient]
+		util.LogInfo(fmt.Sprintf("Received \"%s\" for %s", item.Title, recipient))
+		srv.sourceFeedsChan <- &FeedChanItem{
+			source: source,
+			feed: gofeed.Feed{
+				Title: recipient,
+				Link:  source.url,
+				Items: []*gofeed.Item{item},
+			},
+		}
+	}
+}
+
+func (srv *smtpServer) handleConn(netConn net.Conn) {
+	defer srv.handlers.Done()
+	defer func() {
+		srv.connsMutex.Lock()
+		delete(srv.conns, netConn)
+		srv.connsMutex.Unlock()
+	}()
+
+	conn := textproto.NewConn(netConn)
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			util.LogWarn(err)
+		}
+	}()
+
+	reply := func(code int, msg string) bool {
+		_ = netConn.SetDeadline(time.Now().Add(smtpTimeout))
+		err := conn.PrintfLine("%d %s", code, msg)
+		return err == nil
+	}
+
+	if !reply(220, appTitle+" ESMTP") {
+		return
+	}
+
+	var recipients []string
+	hasSender := false
+
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				util.LogWarn(err)
+			}
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		ok := true
+
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			ok = reply(250, appTitle)
+
+		case "EHLO":
+			ok = conn.PrintfLine("250-%s", appTitle) == nil &&
+				conn.PrintfLine("250-SIZE %d", smtpMaxMessageSize) == nil &&
+				reply(250, "8BITMIME")
+
+		case "MAIL":
+			_, isValid := parseSmtpPath(arg, "FROM:")
+			if !isValid {
+				ok = reply(501, "Syntax error in MAIL FROM")
+				break
+			}
+			hasSender = true
+			recipients = nil
+			ok = reply(250, "OK")
+
+		case "RCPT":
+			recipient, isValid := parseSmtpPath(arg, "TO:")
+			if !isValid || recipient == "" {
+				ok = reply(501, "Syntax error in RCPT TO")
+				break
+			}
+			if !hasSender {
+				ok = reply(503, "Need MAIL first")
+				break
+			}
+			if _, isKnown := srv.mailboxes[recipient]; !isKnown {
+				ok = reply(550, "No such mailbox")
+				break
+			}
+			recipients = append(recipients, recipient)
+			ok = reply(250, "OK")
+
+		case "DATA":
+			if len(recipients) == 0 {
+				ok = reply(503, "Need RCPT first")
+				break
+			}
+			if !reply(354, "End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+
+			data, err := io.ReadAll(io.LimitReader(conn.DotReader(), smtpMaxMessageSize+1))
+			if err != nil {
+				util.LogWarn(err)
+				return
+			}
+			if len(data) > smtpMaxMessageSize {
+				// the rest of the message can't be skipped reliably, so just drop the connection
+				reply(552, "Message too big")
+				return
+			}
+
+			srv.deliver(data, recipients)
+			recipients = nil
+			hasSender = false
+			ok = reply(250, "OK")
+
+		case "RSET":
+			recipients = nil
+			hasSender = false
+			ok = reply(250, "OK")
+
+		case "NOOP":
+			ok = reply(250, "OK")
+
+		case "QUIT":
+			reply(221, "Bye")
+			return
+
+		default:
+			ok = reply(502, "Command not implemented")
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+func startSmtpServer(addr string, mailboxAddresses []string, sourceFeedsChan chan *FeedChanItem) *smtpServer {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		util.LogWarn(err)
+		return nil
+	}
+
+	srv := &smtpServer{
+		listener:        listener,
+		mailboxes:       newMailboxSources(mailboxAddresses),
+		sourceFeedsChan: sourceFeedsChan,
+		conns:           map[net.Conn]bool{},
+	}
+
+	util.LogInfo("Starting SMTP server at " + addr)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					util.LogWarn(err)
+				}
+				return
+			}
+			srv.connsMutex.Lock()
+			srv.conns[conn] = true
+			srv.connsMutex.Unlock()
+			srv.handlers.Add(1)
+			go srv.handleConn(conn)
+		}
+	}()
+
+	return srv
+}
+
+// Drops all connections, so the messages that are not fully received yet are lost.
+func (srv *smtpServer) stop() {
+	err := srv.listener.Close()
+	if err != nil {
+		util.LogWarn(err)
+	}
+
+	srv.connsMutex.Lock()
+	for conn := range srv.conns {
+		_ = conn.Close()
+	}
+	srv.connsMutex.Unlock()
+
+	srv.handlers.Wait()
+}
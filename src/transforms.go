@@ -0,0 +1,314 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/feed_types"
+	"feedmash/util"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var trackingParams = map[string]bool{
+	"fbclid":      true,
+	"gclid":       true,
+	"dclid":       true,
+	"gbraid":      true,
+	"wbraid":      true,
+	"msclkid":     true,
+	"yclid":       true,
+	"igshid":      true,
+	"mc_cid":      true,
+	"mc_eid":      true,
+	"_hsenc":      true,
+	"_hsmi":       true,
+	"mkt_tok":     true,
+	"oly_anon_id": true,
+	"oly_enc_id":  true,
+	"vero_id":     true,
+	"wickedid":    true,
+	"ref":         true,
+	"ref_src":     true,
+	"ref_url":     true,
+}
+
+var hrefRx = regexp.MustCompile(`(?i)(\shref=")([^"]*)(")`)
+
+var replaceFields = []string{"title", "link", "content"}
+
+type replaceRule struct {
+	field string
+	regex *regexp.Regexp
+	with  string
+}
+
+// Changes the items after they are converted.
+type itemTransform struct {
+	stripTracking bool
+	replace       []*replaceRule
+	titlePrefix   *template.Template
+	titleSuffix   *template.Template
+}
+
+// The data that is passed to the title prefix/suffix templates.
+type titleTemplateData struct {
+	Source string
+	Title  string
+}
+
+func getTemplate(v *viper.Viper, key string) *template.Template {
+	text := v.GetString(key)
+	if text == "" {
+		return nil
+	}
+	tpl, err := template.New(key).Parse(text)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid \"%s\" template: %s", key, err))
+	}
+	return tpl
+}
+
+func getReplaceRules(v *viper.Viper, key string) []*replaceRule {
+	var rules []*replaceRule
+	rawRules, ok := v.Get(key).([]interface{})
+	if !ok {
+		if v.IsSet(key) {
+			panic(fmt.Sprintf("\"%s\" must be an array", key))
+		}
+		return rules
+	}
+
+	for _, rawRule := range rawRules {
+		ruleMap, ok := rawRule.(map[string]interface{})
+		if !ok {
+			panic(fmt.Sprintf("Invalid replace rule: %v", rawRule))
+		}
+		options := viper.New()
+		err := options.MergeConfigMap(ruleMap)
+		if err != nil {
+			panic(err)
+		}
+
+		field := strings.ToLower(getString(options, "field", "title"))
+		isKnown := false
+		for _, knownField := range replaceFields {
+			isKnown = isKnown || field == knownField
+		}
+		if !isKnown {
+			panic(fmt.Sprintf("Unknown replace field \"%s\", use one of: %s", field, strings.Join(replaceFields, ", ")))
+		}
+
+		rx, err := regexp.Compile(options.GetString("regex"))
+		if err != nil || options.GetString("regex") == "" {
+			panic(fmt.Sprintf("Invalid replace regex: %v: %v", rawRule, err))
+		}
+
+		rules = append(rules, &replaceRule{
+			field: field,
+			regex: rx,
+			with:  options.GetString("with"),
+		})
+	}
+	return rules
+}
+
+// The transform is a map with the "stripTracking", "replace", "titlePrefix" and "titleSuffix" keys.
+func getTransform(v *viper.Viper, key string) *itemTransform {
+	rawTransform, ok := v.Get(key).(map[string]interface{})
+	if !ok {
+		if v.IsSet(key) {
+			panic(fmt.Sprintf("\"%s\" must be a map", key))
+		}
+		return nil
+	}
+
+	options := viper.New()
+	err := options.MergeConfigMap(rawTransform)
+	if err != nil {
+		panic(err)
+	}
+
+	return &itemTransform{
+		stripTracking: options.GetBool("stripTracking"),
+		replace:       getReplaceRules(options, "replace"),
+		titlePrefix:   getTemplate(options, "titlePrefix"),
+		titleSuffix:   getTemplate(options, "titleSuffix"),
+	}
+}
+
+// Removes utm_* and other tracking parameters from the URL.
+// The URL is returned as is if there's nothing to remove.
+func stripTrackingParams(urlStr string) string {
+	urlObj, err := url.Parse(urlStr)
+	if err != nil || urlObj.RawQuery == "" {
+		return urlStr
+	}
+
+	params := strings.Split(urlObj.RawQuery, "&")
+	var keptParams []string
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		key, err = url.QueryUnescape(key)
+		if err == nil {
+			key = strings.ToLower(key)
+			if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+				continue
+			}
+		}
+		keptParams = append(keptParams, param)
+	}
+	if len(keptParams) == len(params) {
+		return urlStr
+	}
+
+	urlObj.RawQuery = strings.Join(keptParams, "&")
+	return urlObj.String()
+}
+
+func stripTrackingParamsInHtml(s string) string {
+	return hrefRx.ReplaceAllStringFunc(s, func(attr string) string {
+		parts := hrefRx.FindStringSubmatch(attr)
+		link := html.UnescapeString(parts[2])
+		strippedLink := stripTrackingParams(link)
+		if strippedLink == link {
+			return attr
+		}
+		return parts[1] + html.EscapeString(strippedLink) + parts[3]
+	})
+}
+
+func renderTitleTemplate(tpl *template.Template, data titleTemplateData) string {
+	var sb strings.Builder
+	err := tpl.Execute(&sb, data)
+	if err != nil {
+		util.LogWarn(err)
+		return ""
+	}
+	return sb.String()
+}
+
+func (transform *itemTransform) apply(item *feeds.Item, sourceName string) {
+	if transform.stripTracking {
+		if item.Link != nil {
+			item.Link.Href = stripTrackingParams(item.Link.Href)
+		}
+		item.Content = stripTrackingParamsInHtml(item.Content)
+		item.Description = stripTrackingParamsInHtml(item.Description)
+	}
+
+	for _, rule := range transform.replace {
+		switch rule.field {
+		case "title":
+			item.Title = rule.regex.ReplaceAllString(item.Title, rule.with)
+
+		case "link":
+			if item.Link != nil {
+				item.Link.Href = rule.regex.ReplaceAllString(item.Link.Href, rule.with)
+			}
+
+		case "content":
+			item.Content = rule.regex.ReplaceAllString(item.Content, rule.with)
+			item.Description = rule.regex.ReplaceAllString(item.Description, rule.with)
+		}
+	}
+
+	// the source of some reloaded items is unknown
+	if sourceName == "" {
+		return
+	}
+
+	// the titles that already have the prefix/suffix are kept, so the transforms can be applied again
+	data := titleTemplateData{
+		Source: sourceName,
+		Title:  item.Title,
+	}
+	if transform.titlePrefix != nil {
+		prefix := renderTitleTemplate(transform.titlePrefix, data)
+		if !strings.HasPrefix(item.Title, prefix) {
+			item.Title = prefix + item.Title
+		}
+	}
+	if transform.titleSuffix != nil {
+		suffix := renderTitleTemplate(transform.titleSuffix, data)
+		if !strings.HasSuffix(item.Title, suffix) {
+			item.Title += suffix
+		}
+	}
+}
+
+// Wraps the item conversion function of a source to also apply the transforms.
+// The transforms are idempotent, so they're also applied to the reloaded items (see withReloadTransforms).
+func withTransforms(
+	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	sourceName string,
+	transforms ...*itemTransform,
+) func(item *gofeed.Item) *feeds.Item {
+	return func(item *gofeed.Item) *feeds.Item {
+		outItem := sourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			return nil
+		}
+		for _, transform := range transforms {
+			if transform != nil {
+				transform.apply(outItem, sourceName)
+			}
+		}
+		return outItem
+	}
+}
+
+// Applies the transforms to the items of the saved output feed, so the changed rules apply to them too.
+// The source of an item is found by its ID; only the global transforms apply to the items of unknown sources.
+func withReloadTransforms(
+	sourceFeedItemToOutFeedItem func(item *gofeed.Item) *feeds.Item,
+	cfg Config,
+) func(item *gofeed.Item) *feeds.Item {
+	sources := map[string]SourceConfig{}
+	for _, sourceCfg := range cfg.sources {
+		sources[sourceKey(sourceCfg.url)] = sourceCfg
+	}
+
+	return func(item *gofeed.Item) *feeds.Item {
+		outItem := sourceFeedItemToOutFeedItem(item)
+		if outItem == nil {
+			return nil
+		}
+
+		sourceCfg, isKnown := sources[itemSourceKey("", outItem.Id)]
+		sourceName := ""
+		if isKnown {
+			sourceName = reloadedSourceName(item, sourceCfg)
+			if sourceCfg.transform != nil {
+				sourceCfg.transform.apply(outItem, sourceName)
+			}
+		}
+		if cfg.transform != nil {
+			cfg.transform.apply(outItem, sourceName)
+		}
+		return outItem
+	}
+}
+
+// The same name as FeedSource.displayName gives, with the feed title taken from the saved item.
+func reloadedSourceName(item *gofeed.Item, sourceCfg SourceConfig) string {
+	name := sourceCfg.options.GetString("name")
+	if name != "" {
+		return name
+	}
+	if origin := feed_types.ItemOriginOf(item, nil, ""); origin != nil && strings.TrimSpace(origin.Title) != "" {
+		return strings.TrimSpace(origin.Title)
+	}
+	urlObj, err := url.Parse(sourceCfg.url)
+	if err == nil && urlObj.Host != "" {
+		return urlObj.Host
+	}
+	return sourceCfg.url
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"feedmash/feed_types"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func newTestTransform(t *testing.T, rawTransform map[string]interface{}) *itemTransform {
+	v := viper.New()
+	v.Set("transforms", rawTransform)
+	transform := getTransform(v, "transforms")
+	if transform == nil {
+		t.Fatal("no transform")
+	}
+	return transform
+}
+
+func TestStripTrackingParams(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://example.com/a?utm_source=x&id=1&fbclid=y", "https://example.com/a?id=1"},
+		{"https://example.com/a?UTM_Medium=x", "https://example.com/a"},
+		{"https://example.com/a?id=1&b=2", "https://example.com/a?id=1&b=2"},
+		{"https://example.com/a", "https://example.com/a"},
+	}
+	for _, test := range tests {
+		got := stripTrackingParams(test.url)
+		if got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.url, got, test.expected)
+		}
+	}
+}
+
+func TestTransformIsIdempotent(t *testing.T) {
+	transform := newTestTransform(t, map[string]interface{}{
+		"stripTracking": true,
+		"replace": []interface{}{
+			map[string]interface{}{"regex": `^\[ANN\]\s*`, "with": ""},
+		},
+		"titlePrefix": "{{.Source}}: ",
+		"titleSuffix": " ({{.Source}})",
+	})
+
+	item := feed_types.HttpSourceFeedItemToOutFeedItem(&gofeed.Item{
+		Title:   "[ANN] Release",
+		Link:    "https://example.com/post?utm_source=rss",
+		Content: `<a href="https://example.com/?fbclid=x&amp;a=1">link</a>`,
+	})
+	for i := 0; i < 2; i++ {
+		transform.apply(item, "Blog")
+		if item.Title != "Blog: Release (Blog)" {
+			t.Errorf("pass %d: title: %q", i+1, item.Title)
+		}
+		if item.Link.Href != "https://example.com/post" {
+			t.Errorf("pass %d: link: %q", i+1, item.Link.Href)
+		}
+		if item.Content != `<a href="https://example.com/?a=1">link</a>` {
+			t.Errorf("pass %d: content: %q", i+1, item.Content)
+		}
+	}
+}
+
+func TestReloadTransforms(t *testing.T) {
+	sourceUrl := "https://example.com/feed.xml"
+	cfg := Config{
+		sources: []SourceConfig{{
+			url:       sourceUrl,
+			options:   viper.New(),
+			transform: newTestTransform(t, map[string]interface{}{"titlePrefix": "{{.Source}}: "}),
+		}},
+		transform: newTestTransform(t, map[string]interface{}{"stripTracking": true}),
+	}
+	convert := withReloadTransforms(feed_types.HttpSourceFeedItemToOutFeedItem, cfg)
+
+	tests := []struct {
+		name  string
+		item  *gofeed.Item
+		title string
+		link  string
+	}{
+		{
+			"already transformed",
+			&gofeed.Item{GUID: scopedItemId(sourceUrl, "1"), Title: "example.com: Post"},
+			"example.com: Post", "",
+		},
+		{
+			"saved before the prefix was added",
+			&gofeed.Item{GUID: scopedItemId(sourceUrl, "2"), Title: "Post"},
+			"example.com: Post", "",
+		},
+		{
+			"the name from the saved source title",
+			&gofeed.Item{
+				GUID:   scopedItemId(sourceUrl, "3"),
+				Title:  "Post",
+				Custom: map[string]string{"source:title": "Example Blog"},
+			},
+			"Example Blog: Post", "",
+		},
+		{
+			"unknown source",
+			&gofeed.Item{GUID: "legacy-id", Title: "Post", Link: "https://example.com/?utm_source=x"},
+			"Post", "https://example.com/",
+		},
+	}
+
+	for _, test := range tests {
+		outItem := convert(test.item)
+		if outItem.Title != test.title {
+			t.Errorf("%s: title: %q", test.name, outItem.Title)
+		}
+		if test.link != "" && outItem.Link.Href != test.link {
+			t.Errorf("%s: link: %q", test.name, outItem.Link.Href)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"feedmash/util"
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/spf13/viper"
+	"time"
+)
+
+type itemVersion struct {
+	Hash    string     `json:"hash"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// The versions of the output items, by their ID.
+type itemUpdatesState struct {
+	Items map[string]itemVersion `json:"items"`
+}
+
+// Replaces the output items when their source changes them, e.g. when the title is corrected.
+type itemUpdates struct {
+	resurface      bool
+	stateKey       string
+	state          itemUpdatesState
+	isStateLoaded  bool
+	isStateChanged bool
+	changed        bool
+}
+
+// Returns nil if the updates are not tracked.
+func getUpdates(v *viper.Viper, outFeedFilename string) *itemUpdates {
+	if !getBool(v, "trackUpdates", true) {
+		return nil
+	}
+	return &itemUpdates{
+		resurface: getBool(v, "resurfaceUpdated", false),
+		stateKey:  util.StateKey("updates", outFeedFilename),
+	}
+}
+
+func outItemHash(outItem *feeds.Item) string {
+	hash := sha1.New()
+	for _, s := range []string{outItem.Title, itemLink(outItem), outItem.Description, outItem.Content} {
+		hash.Write([]byte(s))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (updates *itemUpdates) loadState() {
+	if updates.isStateLoaded {
+		return
+	}
+	util.LoadState(updates.stateKey, &updates.state)
+	if updates.state.Items == nil {
+		updates.state.Items = map[string]itemVersion{}
+	}
+	updates.isStateLoaded = true
+}
+
+func (updates *itemUpdates) remember(item *gofeed.Item, outItem *feeds.Item) {
+	updates.loadState()
+	updates.state.Items[outItem.Id] = itemVersion{
+		Hash:    outItemHash(outItem),
+		Updated: item.UpdatedParsed,
+	}
+	updates.isStateChanged = true
+}
+
+// Returns true if the item is already in the items,
+// in which case the existing item is replaced with the new version if it's changed.
+// If the source has the updated date of the item, then only the items with a newer date are checked.
+func (updates *itemUpdates) mergeUpdate(
+	items []*feeds.Item,
+	item *gofeed.Item,
+	outItem *feeds.Item,
+	source itemSource,
+	dedup *itemDedup,
+	extras itemExtras,
+) bool {
+	var existingItem *feeds.Item
+	for _, resultItem := range items {
+		if resultItem.Id == outItem.Id {
+			existingItem = resultItem
+			break
+		}
+	}
+	if existingItem == nil {
+		return false
+	}
+
+	updates.loadState()
+	version, isKnown := updates.state.Items[outItem.Id]
+	if !isKnown {
+		// e.g. the items that were saved before the updates were tracked
+		updates.remember(item, outItem)
+		return true
+	}
+	if version.Updated != nil && item.UpdatedParsed != nil && !item.UpdatedParsed.After(*version.Updated) {
+		return true
+	}
+	if outItemHash(outItem) == version.Hash {
+		if item.UpdatedParsed != nil {
+			updates.remember(item, outItem)
+		}
+		return true
+	}
+	updates.remember(item, outItem)
+
+	updated := time.Now()
+	if item.UpdatedParsed != nil {
+		updated = *item.UpdatedParsed
+	}
+	updated = updated.Local()
+
+	existingItem.Title = outItem.Title
+	existingItem.Link = outItem.Link
+	existingItem.Description = outItem.Description
+	existingItem.Author = outItem.Author
+	existingItem.Enclosure = outItem.Enclosure
+	dedup.setContent(existingItem, outItem.Content)
+	extras.set(existingItem.Id, item, source)
+	// the original date is kept, the resurfaced items are sorted by the updated date instead
+	existingItem.Updated = updated
+
+	updates.changed = true
+	util.LogInfo("Updated item: " + existingItem.Title)
+	return true
+}
+
+// Returns true if the updated items must be moved to the top of the feed.
+func (updates *itemUpdates) isResurfacing() bool {
+	return updates != nil && updates.resurface
+}
+
+// Forgets the items that are no longer in the output feed and saves the state.
+func (updates *itemUpdates) prune(items []*feeds.Item) {
+	if !updates.isStateLoaded {
+		return
+	}
+
+	ids := map[string]bool{}
+	for _, item := range items {
+		ids[item.Id] = true
+	}
+	for id := range updates.state.Items {
+		if !ids[id] {
+			delete(updates.state.Items, id)
+			updates.isStateChanged = true
+		}
+	}
+
+	if updates.isStateChanged {
+		util.SaveState(updates.stateKey, updates.state)
+		updates.isStateChanged = false
+	}
+}
+
+// Returns true if any existing item was changed since the last call.
+func (updates *itemUpdates) takeChanged() bool {
+	changed := updates.changed
+	updates.changed = false
+	return changed
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package src
+
+import (
+	"github.com/mmcdole/gofeed"
+	"testing"
+	"time"
+)
+
+func testUpdatedItem(item *gofeed.Item, title string, updatedDay int) *gofeed.Item {
+	newItem := *item
+	newItem.Title = title
+	newItem.Content = "<p>" + title + "</p>"
+	if updatedDay > 0 {
+		updated := time.Date(2024, 5, updatedDay, 0, 0, 0, 0, time.UTC)
+		newItem.UpdatedParsed = &updated
+	}
+	return &newItem
+}
+
+func TestUpdateDetection(t *testing.T) {
+	original := testItem("1", "Title", "https://example.com/1", 1)
+	dated := testUpdatedItem(original, "Title", 2)
+
+	tests := []struct {
+		name      string
+		first     *gofeed.Item
+		second    *gofeed.Item
+		isUpdated bool
+	}{
+		{"the same item", original, original, false},
+		{"changed title", original, testUpdatedItem(original, "Title, corrected", 0), true},
+		{"changed link", original, testItem("1", "Title", "https://example.com/one", 1), true},
+		{"newer date", dated, testUpdatedItem(original, "Title, corrected", 3), true},
+		{"newer date, same content", dated, testUpdatedItem(original, "Title", 3), false},
+		{"the same date", dated, testUpdatedItem(original, "Title, corrected", 2), false},
+		{"older date", dated, testUpdatedItem(original, "Title, corrected", 1), false},
+	}
+
+	for _, test := range tests {
+		poller := newTestPoller(t, Config{updates: &itemUpdates{}})
+		poller.poll(testSourceA, "A", test.first)
+		created := poller.items[0].Created
+		poller.poll(testSourceA, "A", test.second)
+
+		if len(poller.items) != 1 {
+			t.Errorf("%s: expected 1 item, got %d", test.name, len(poller.items))
+			continue
+		}
+		if poller.cfg.updates.takeChanged() != test.isUpdated {
+			t.Errorf("%s: expected updated = %v", test.name, test.isUpdated)
+		}
+		if test.isUpdated && poller.items[0].Title != test.second.Title {
+			t.Errorf("%s: the item is not replaced: %q", test.name, poller.items[0].Title)
+		}
+		if !poller.items[0].Created.Equal(created) {
+			t.Errorf("%s: the original date must be kept: %v", test.name, poller.items[0].Created)
+		}
+	}
+}
+
+func TestUpdatesAfterRestart(t *testing.T) {
+	poller := newTestPoller(t, Config{updates: &itemUpdates{stateKey: "updates"}})
+	item := testItem("1", "Title", "https://example.com/1", 1)
+	poller.poll(testSourceA, "A", item)
+
+	poller.restart()
+	poller.cfg.updates = &itemUpdates{stateKey: "updates"}
+	poller.poll(testSourceA, "A", item)
+	if poller.cfg.updates.takeChanged() {
+		t.Error("the same item must not be updated after a restart")
+	}
+	poller.poll(testSourceA, "A", testUpdatedItem(item, "Title, corrected", 0))
+	if !poller.cfg.updates.takeChanged() {
+		t.Error("the changed item must be updated after a restart")
+	}
+}
+
+func TestResurfacing(t *testing.T) {
+	older := testItem("1", "Older", "https://example.com/1", 1)
+	newer := testItem("2", "Newer", "https://example.com/2", 2)
+
+	tests := []struct {
+		resurface bool
+		expected  []string
+	}{
+		{false, []string{"Newer", "Older, corrected"}},
+		{true, []string{"Older, corrected", "Newer"}},
+	}
+
+	for _, test := range tests {
+		poller := newTestPoller(t, Config{updates: &itemUpdates{resurface: test.resurface, stateKey: "updates"}})
+		poller.poll(testSourceA, "A", older, newer)
+		poller.expectItems("initial", "Newer", "Older")
+
+		poller.poll(testSourceA, "A", testUpdatedItem(older, "Older, corrected", 3), newer)
+		poller.expectItems("updated", test.expected...)
+
+		// the order is kept after a restart
+		poller.restart()
+		poller.expectItems("restart", test.expected...)
+	}
+}
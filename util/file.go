@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Writes the file atomically (through a temporary file).
+func SaveToFile(filename string, s string) error {
+	tmpFilename := filename + ".tmp"
+
+	dir := filepath.Dir(tmpFilename)
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(s)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// 🄯 2021, Alexey Parfenov <zxed@alkatrazstudio.net>
+
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The directory for the data that must survive restarts,
+// e.g. which items were already seen by the source.
+var stateDir = ""
+
+// Serializes access to the state files.
+var stateMutex sync.Mutex
+
+func SetStateDir(dir string) {
+	stateDir = dir
+}
+
+func StateDir() string {
+	return stateDir
+}
+
+// Makes a file name that is safe to use for any string, e.g. a source URL.
+func StateKey(prefix string, s string) string {
+	hash := sha1.Sum([]byte(s))
+	return prefix + "-" + hex.EncodeToString(hash[:])
+}
+
+func stateFilename(name string) string {
+	return filepath.Join(stateDir, name+".json")
+}
+
+// Returns false if there's no saved state yet.
+func LoadState(name string, v interface{}) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	data, err := os.ReadFile(stateFilename(name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			LogWarn(err)
+		}
+		return false
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		LogWarn(err)
+		return false
+	}
+
+	return true
+}
+
+func SaveState(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		LogWarn(err)
+		return
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	err = SaveToFile(stateFilename(name), string(data))
+	if err != nil {
+		LogWarn(err)
+	}
+}